@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"go.uber.org/zap"
+
+	"wb-tech-1task/internal/cache"
+	"wb-tech-1task/internal/config"
+	"wb-tech-1task/internal/db/postgres"
+	"wb-tech-1task/internal/kafka"
+	"wb-tech-1task/internal/models"
+	"wb-tech-1task/internal/service"
+)
+
+const usage = `usage: dlq <command> [flags]
+
+commands:
+  list    list dead-lettered messages
+  show    print a single message by -partition and -offset
+  replay  re-publish messages to the main topic (-mode publish)
+          or save them through the order service (-mode save)
+
+run "dlq <command> -h" for command flags`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var err error
+	switch os.Args[1] {
+	case "list":
+		err = runList(ctx, os.Args[2:])
+	case "show":
+		err = runShow(ctx, os.Args[2:])
+	case "replay":
+		err = runReplay(ctx, os.Args[2:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dlq: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+type commonFlags struct {
+	brokers   string
+	topic     string
+	errorLike string
+	key       string
+	partition int
+	offset    int64
+	limit     int
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *commonFlags) {
+	cf := &commonFlags{}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&cf.brokers, "brokers", envOr("KAFKA_BROKERS", "localhost:9092"), "comma-separated kafka brokers")
+	fs.StringVar(&cf.topic, "topic", envOr("KAFKA_TOPIC", "orders"), "main topic; the dead letter topic is <topic>_dead_letter")
+	fs.StringVar(&cf.errorLike, "error", "", "only messages whose error header contains this text")
+	fs.StringVar(&cf.key, "key", "", "only messages with this key")
+	fs.IntVar(&cf.partition, "partition", -1, "only messages from this partition")
+	fs.Int64Var(&cf.offset, "offset", -1, "only the message at this offset")
+	fs.IntVar(&cf.limit, "limit", 0, "stop after this many matching messages (0 = all)")
+	return fs, cf
+}
+
+func (cf *commonFlags) filter() kafka.DeadLetterFilter {
+	f := kafka.DeadLetterFilter{ErrorContains: cf.errorLike, Key: cf.key, Limit: cf.limit}
+	if cf.partition >= 0 {
+		f.Partition = &cf.partition
+	}
+	if cf.offset >= 0 {
+		f.Offset = &cf.offset
+	}
+	return f
+}
+
+func (cf *commonFlags) brokerList() []string {
+	return strings.Split(cf.brokers, ",")
+}
+
+func runList(ctx context.Context, args []string) error {
+	fs, cf := newFlagSet("list")
+	_ = fs.Parse(args)
+
+	replayer := kafka.NewDeadLetterReplayer(cf.brokerList(), cf.topic, nil)
+	defer replayer.Close()
+
+	list, err := replayer.List(ctx, cf.filter())
+	if err != nil {
+		return err
+	}
+	return printJSON(list)
+}
+
+func runShow(ctx context.Context, args []string) error {
+	fs, cf := newFlagSet("show")
+	_ = fs.Parse(args)
+	if cf.partition < 0 || cf.offset < 0 {
+		return fmt.Errorf("show requires -partition and -offset")
+	}
+
+	replayer := kafka.NewDeadLetterReplayer(cf.brokerList(), cf.topic, nil)
+	defer replayer.Close()
+
+	dl, err := replayer.Get(ctx, cf.partition, cf.offset)
+	if err != nil {
+		return err
+	}
+	return printJSON(dl)
+}
+
+func runReplay(ctx context.Context, args []string) error {
+	fs, cf := newFlagSet("replay")
+	mode := fs.String("mode", kafka.ReplayPublish, "publish (back to the main topic) or save (through the order service)")
+	dryRun := fs.Bool("dry-run", false, "only show what would be replayed")
+	_ = fs.Parse(args)
+
+	var saver kafka.OrderSaver
+	if *mode == kafka.ReplaySave && !*dryRun {
+		svc, closeFn, err := newOrderService()
+		if err != nil {
+			return err
+		}
+		defer closeFn()
+		saver = svc
+	}
+
+	replayer := kafka.NewDeadLetterReplayer(cf.brokerList(), cf.topic, saver)
+	defer replayer.Close()
+
+	result, err := replayer.Replay(ctx, cf.filter(), *mode, *dryRun)
+	if err != nil {
+		return err
+	}
+	return printJSON(result)
+}
+
+func newOrderService() (*service.OrderService, func(), error) {
+	cfg, err := config.LoadFromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	// the same rules as the server, so a replay saves nothing it would reject
+	rules := models.DefaultRuleEngine()
+	if err := rules.SetModes(cfg.OrderRules); err != nil {
+		return nil, nil, fmt.Errorf("invalid ORDER_RULES: %w", err)
+	}
+	repo, err := postgres.NewPostgresRepository(cfg.DatabaseURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := cache.New(0)
+	svc := service.NewOrderService(c, repo, zap.NewNop())
+	svc.SetRules(rules)
+	return svc, func() {
+		c.Close()
+		_ = repo.Close()
+	}, nil
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
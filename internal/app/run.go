@@ -3,6 +3,8 @@ package app
 import (
 	"context"
 	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 	"net/http"
@@ -11,9 +13,13 @@ import (
 	"wb-tech-1task/internal/cache"
 	"wb-tech-1task/internal/config"
 	"wb-tech-1task/internal/db/postgres"
+	"wb-tech-1task/internal/health"
 	"wb-tech-1task/internal/kafka"
+	"wb-tech-1task/internal/metrics"
+	"wb-tech-1task/internal/models"
 	"wb-tech-1task/internal/server"
 	"wb-tech-1task/internal/service"
+	"wb-tech-1task/internal/warmup"
 )
 
 func Run(ctx context.Context, cfg *config.Config, logger *zap.Logger) error {
@@ -27,27 +33,78 @@ func Run(ctx context.Context, cfg *config.Config, logger *zap.Logger) error {
 	}
 	defer repo.Close()
 
-	c := cache.New(cfg.CacheTTL)
+	c := cache.NewBounded(cfg.CacheTTL, cfg.CacheMaxEntries, cfg.CacheMaxBytes)
 	defer c.Close()
 
-	loadCtx, loadCancel := context.WithTimeout(ctx, 10*time.Second)
-	orders, err := repo.GetAllOrders(loadCtx)
-	loadCancel()
-	if err != nil {
-		logger.Sugar().Errorf("failed to load orders for cache: %v", err)
+	warmer := warmup.New(repo, c, cfg.CacheWarmupBatch, cfg.CacheWarmupLimit, logger)
+
+	for _, collector := range []prometheus.Collector{
+		metrics.NewCacheCollector(c),
+		collectors.NewDBStatsCollector(repo.DB(), "orders"),
+	} {
+		if err := metrics.Registry.Register(collector); err != nil {
+			logger.Sugar().Warnf("failed to register metrics collector: %v", err)
+		}
+	}
+
+	svc := service.NewOrderServiceWithNegativeTTL(c, repo, logger, cfg.CacheNegativeTTL)
+
+	rules := models.DefaultRuleEngine()
+	if err := rules.SetModes(cfg.OrderRules); err != nil {
+		logger.Sugar().Errorf("invalid ORDER_RULES: %v", err)
 		return err
 	}
-	if err := c.DBBackup(orders); err != nil {
-		logger.Sugar().Errorf("failed to fill cache: %v", err)
+	svc.SetRules(rules)
+
+	retryTiers, err := kafka.ParseRetryTiers(cfg.KafkaRetryTiers)
+	if err != nil {
+		logger.Sugar().Errorf("invalid kafka retry configuration: %v", err)
 		return err
 	}
-	logger.Sugar().Infof("restored %d orders into cache", c.Count())
+	consumerOpts := kafka.DefaultConsumerOptions()
+	consumerOpts.Retry.MaxAttempts = cfg.KafkaRetryAttempts
+	consumerOpts.Retry.InitialBackoff = cfg.KafkaRetryBackoff
+	consumerOpts.Retry.Tiers = retryTiers
+	consumerOpts.Workers = cfg.KafkaWorkers
+	consumerOpts.CommitInterval = cfg.KafkaCommitInterval
+	consumerOpts.CommitBatch = cfg.KafkaCommitBatch
+	consumerOpts.BatchSize = cfg.KafkaBatchSize
 
-	svc := service.NewOrderService(c, repo, logger)
+	consumer := kafka.NewConsumer(cfg.KafkaBrokers, cfg.KafkaTopic, cfg.KafkaGroup, svc, consumerOpts)
+	consumers := []*kafka.Consumer{consumer}
+	for i, tier := range retryTiers {
+		// Each tier has its own group so its readers joining or stalling never rebalance the main topic.
+		group := cfg.KafkaGroup + "-retry-" + tier.Name
+		consumers = append(consumers, kafka.NewRetryConsumer(cfg.KafkaBrokers, cfg.KafkaTopic, group, svc, consumerOpts, i))
+	}
+
+	registry := health.NewRegistry(2 * time.Second)
+	registry.Register("postgres", health.CheckerFunc(repo.Ping), true)
+	registry.Register("cache", health.CheckerFunc(c.Ping), true)
+	registry.Register("kafka", health.CheckerFunc(consumer.Ping), false)
+
+	var relay *kafka.OutboxRelay
+	if cfg.OutboxTopic != "" {
+		relay = kafka.NewOutboxRelay(cfg.KafkaBrokers, cfg.OutboxTopic, repo, kafka.OutboxOptions{
+			PollInterval: cfg.OutboxPollInterval,
+			BatchSize:    cfg.OutboxBatch,
+		}, logger)
+	} else {
+		logger.Sugar().Warn("OUTBOX_TOPIC is empty; order events stay in the outbox table")
+	}
 
-	consumer := kafka.NewConsumer(cfg.KafkaBrokers, cfg.KafkaTopic, cfg.KafkaGroup, svc)
+	replayer := kafka.NewDeadLetterReplayer(cfg.KafkaBrokers, cfg.KafkaTopic, svc)
+	if cfg.AdminToken == "" {
+		logger.Sugar().Warn("ADMIN_TOKEN is not set; admin endpoints are disabled")
+	}
 
-	router := server.NewRouter(svc, logger)
+	router := server.NewRouter(svc, server.RouterOptions{
+		Health:      registry,
+		Warmup:      warmer,
+		DeadLetters: replayer,
+		Stats:       service.NewStatsService(repo, cfg.StatsCacheTTL, logger),
+		AdminToken:  cfg.AdminToken,
+	}, logger)
 	srv := &http.Server{
 		Addr:    cfg.HTTPAddr,
 		Handler: router,
@@ -55,30 +112,69 @@ func Run(ctx context.Context, cfg *config.Config, logger *zap.Logger) error {
 
 	g, gctx := errgroup.WithContext(ctx)
 
+	if cfg.CacheInvalidation {
+		listener := postgres.NewChangeListener(cfg.DatabaseURL, c, svc, logger)
+		g.Go(func() error {
+			if err := listener.Run(gctx); err != nil {
+				logger.Sugar().Errorf("order changes listener error: %v", err)
+				return err
+			}
+			return nil
+		})
+	}
+
 	g.Go(func() error {
-		logger.Sugar().Infof("http server listening on %s", srv.Addr)
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			logger.Sugar().Errorf("http server error: %v", err)
-			return err
-		}
+		// warm-up failures are not fatal: cache misses fall back to the DB
+		_ = warmer.Run(gctx)
 		return nil
 	})
 
 	g.Go(func() error {
-		logger.Sugar().Info("kafka consumer starting")
-		if err := consumer.Run(gctx); err != nil {
-			logger.Sugar().Errorf("consumer run error: %v", err)
+		logger.Sugar().Infof("http server listening on %s", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Sugar().Errorf("http server error: %v", err)
 			return err
 		}
 		return nil
 	})
 
+	if cfg.ProcessedRetention > 0 {
+		cleaner := service.NewProcessedCleaner(repo, cfg.ProcessedRetention, logger)
+		g.Go(func() error {
+			return cleaner.Run(gctx)
+		})
+	}
+
+	if relay != nil {
+		g.Go(func() error {
+			logger.Sugar().Infof("outbox relay publishing to %s", cfg.OutboxTopic)
+			return relay.Run(gctx)
+		})
+	}
+
+	for _, cons := range consumers {
+		g.Go(func() error {
+			logger.Sugar().Info("kafka consumer starting")
+			if err := cons.Run(gctx); err != nil {
+				logger.Sugar().Errorf("consumer run error: %v", err)
+				return err
+			}
+			return nil
+		})
+	}
+
 	err = g.Wait()
 
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 	_ = srv.Shutdown(shutdownCtx)
-	_ = consumer.Close()
+	for _, cons := range consumers {
+		_ = cons.Close()
+	}
+	if relay != nil {
+		_ = relay.Close()
+	}
+	_ = replayer.Close()
 	_ = repo.Close()
 	c.Close()
 
@@ -1,28 +1,146 @@
 package cache
 
 import (
+	"container/list"
+	"context"
 	"errors"
+	"sort"
 	"sync"
 	"time"
 
 	"wb-tech-1task/internal/models"
 )
 
+type entry struct {
+	order     *models.Order
+	expiresAt time.Time
+	size      int64
+}
+
+// Cache is an LRU cache of orders bounded by entry count and by an
+// approximate memory budget. Zero limits mean unbounded.
 type Cache struct {
-	sync.RWMutex
-	data            map[string]*models.Order
-	expirationTimes map[string]time.Time
-	ttl             time.Duration
+	sync.Mutex
+	items      map[string]*list.Element
+	lru        *list.List
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	evictions  uint64
+	hits       uint64
+	misses     uint64
+
+	// byTransaction maps a payment transaction to the UIDs of the cached
+	// orders paid with it; transactions are unique, so one hit is enough.
+	byTransaction index
+	// Track numbers and rids are not unique, so their lookups also know for
+	// which keys every matching order is cached.
+	byTrack *lookup
+	byRid   *lookup
+	// lookupGen changes whenever an order is deleted or may have been written
+	// without this cache seeing its keys; a lookup loaded before that is stale.
+	lookupGen uint64
 
 	stop chan struct{}
 }
 
+type index map[string]map[string]struct{}
+
+func (ix index) add(key, orderUID string) {
+	if key == "" {
+		return
+	}
+	uids, ok := ix[key]
+	if !ok {
+		uids = make(map[string]struct{}, 1)
+		ix[key] = uids
+	}
+	uids[orderUID] = struct{}{}
+}
+
+func (ix index) remove(key, orderUID string) {
+	uids, ok := ix[key]
+	if !ok {
+		return
+	}
+	delete(uids, orderUID)
+	if len(uids) == 0 {
+		delete(ix, key)
+	}
+}
+
+// lookup indexes cached orders by a non-unique key. complete holds the keys
+// for which a DB load found every matching order; a key stops being complete
+// as soon as one of its orders leaves the cache.
+type lookup struct {
+	uids     index
+	complete map[string]struct{}
+	keys     func(order *models.Order) []string
+}
+
+func newLookup(keys func(order *models.Order) []string) *lookup {
+	return &lookup{uids: make(index), complete: make(map[string]struct{}), keys: keys}
+}
+
+func (l *lookup) add(order *models.Order) {
+	for _, key := range l.keys(order) {
+		l.uids.add(key, order.OrderUID)
+	}
+}
+
+func (l *lookup) remove(order *models.Order) {
+	for _, key := range l.keys(order) {
+		l.uids.remove(key, order.OrderUID)
+	}
+}
+
+func (l *lookup) forget(order *models.Order) {
+	for _, key := range l.keys(order) {
+		delete(l.complete, key)
+	}
+}
+
+func (l *lookup) reset() {
+	l.uids = make(index)
+	l.complete = make(map[string]struct{})
+}
+
+func trackKeys(order *models.Order) []string {
+	return []string{order.TrackNumber}
+}
+
+func ridKeys(order *models.Order) []string {
+	rids := make([]string, 0, len(order.Items))
+	for _, it := range order.Items {
+		rids = append(rids, it.Rid)
+	}
+	return rids
+}
+
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Bytes     int64
+}
+
 func New(ttl time.Duration) *Cache {
+	return NewBounded(ttl, 0, 0)
+}
+
+func NewBounded(ttl time.Duration, maxEntries int, maxBytes int64) *Cache {
 	c := &Cache{
-		data:            make(map[string]*models.Order),
-		expirationTimes: make(map[string]time.Time),
-		ttl:             ttl,
-		stop:            make(chan struct{}),
+		items:         make(map[string]*list.Element),
+		lru:           list.New(),
+		ttl:           ttl,
+		maxEntries:    maxEntries,
+		maxBytes:      maxBytes,
+		byTransaction: make(index),
+		byTrack:       newLookup(trackKeys),
+		byRid:         newLookup(ridKeys),
+		stop:          make(chan struct{}),
 	}
 
 	if ttl > 0 {
@@ -40,55 +158,60 @@ func (c *Cache) Close() {
 	}
 }
 
+func (c *Cache) Ping(ctx context.Context) error {
+	select {
+	case <-c.stop:
+		return errors.New("cache is closed")
+	default:
+		return nil
+	}
+}
+
 func (c *Cache) Set(order *models.Order) error {
 	if order == nil {
 		return errors.New("cannot add nil order to cache")
 	}
 	c.Lock()
 	defer c.Unlock()
-	orderCopy := c.copyOrder(order)
 
-	c.data[order.OrderUID] = orderCopy
-
-	if c.ttl > 0 {
-		c.expirationTimes[order.OrderUID] = time.Now().Add(c.ttl)
-	}
-
-	return nil
+	return c.set(order, time.Now())
 }
 
 func (c *Cache) Get(orderUID string) (*models.Order, bool, error) {
-	c.RLock()
-	defer c.RUnlock()
-	order, exists := c.data[orderUID]
+	c.Lock()
+	defer c.Unlock()
+
+	elem, exists := c.items[orderUID]
 	if !exists {
+		c.misses++
 		return nil, false, nil
 	}
 
-	if c.ttl > 0 {
-		if expTime, ok := c.expirationTimes[orderUID]; ok && time.Now().After(expTime) {
-			return nil, false, nil
-		}
+	e := elem.Value.(*entry)
+	if c.expired(e, time.Now()) {
+		c.removeElement(elem)
+		c.misses++
+		return nil, false, nil
 	}
 
-	orderCopy := c.copyOrder(order)
-	return orderCopy, true, nil
+	c.hits++
+	c.lru.MoveToFront(elem)
+	return c.copyOrder(e.order), true, nil
 }
 
 func (c *Cache) GetAll() (map[string]*models.Order, error) {
-	c.RLock()
-	defer c.RUnlock()
+	c.Lock()
+	defer c.Unlock()
 
-	result := make(map[string]*models.Order, len(c.data))
+	result := make(map[string]*models.Order, len(c.items))
 
 	now := time.Now()
-	for k, v := range c.data {
-		if c.ttl > 0 {
-			if expTime, ok := c.expirationTimes[k]; ok && now.After(expTime) {
-				continue
-			}
+	for k, elem := range c.items {
+		e := elem.Value.(*entry)
+		if c.expired(e, now) {
+			continue
 		}
-		result[k] = c.copyOrder(v)
+		result[k] = c.copyOrder(e.order)
 	}
 
 	return result, nil
@@ -98,67 +221,164 @@ func (c *Cache) Delete(orderUID string) {
 	c.Lock()
 	defer c.Unlock()
 
-	delete(c.data, orderUID)
-	delete(c.expirationTimes, orderUID)
+	c.lookupGen++
+	if elem, ok := c.items[orderUID]; ok {
+		c.removeElement(elem)
+	}
 }
 
-func (c *Cache) Cleanup() {
+// EvictOlder removes the cached order if it is older than version, so a copy
+// this instance has just written is kept. Otherwise the order was written
+// elsewhere with keys this cache doesn't know, so no track or rid lookup is
+// complete anymore.
+func (c *Cache) EvictOlder(orderUID string, version int) {
+	c.Lock()
+	defer c.Unlock()
+
+	elem, ok := c.items[orderUID]
+	if ok && elem.Value.(*entry).order.Version >= version {
+		return
+	}
+	if ok {
+		c.removeElement(elem)
+	}
+	c.invalidateLookups()
+}
+
+func (c *Cache) Clear() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.items = make(map[string]*list.Element)
+	c.lru.Init()
+	c.bytes = 0
+	c.byTransaction = make(index)
+	c.byTrack.reset()
+	c.byRid.reset()
+	c.lookupGen++
+}
+
+// FindByTransaction returns the cached orders paid with the given transaction.
+func (c *Cache) FindByTransaction(transaction string) []*models.Order {
+	c.Lock()
+	defer c.Unlock()
+	return c.find(c.byTransaction, transaction)
+}
+
+// FindByTrack returns the cached orders with the given track number and
+// whether they are all the orders with it.
+func (c *Cache) FindByTrack(trackNumber string) ([]*models.Order, bool) {
+	return c.findLookup(c.byTrack, trackNumber)
+}
+
+// FindByRid returns the cached orders containing an item with the given rid
+// and whether they are all the orders containing it.
+func (c *Cache) FindByRid(rid string) ([]*models.Order, bool) {
+	return c.findLookup(c.byRid, rid)
+}
+
+// LookupGen is taken before loading the orders of a track or rid from the DB
+// and passed to SetTrack or SetRid afterwards.
+func (c *Cache) LookupGen() uint64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.lookupGen
+}
+
+// SetTrack caches orders, which a DB load started at gen found to be all the
+// orders with the given track number, and marks the track complete. Nothing
+// is cached if an order may have been written meanwhile.
+func (c *Cache) SetTrack(trackNumber string, orders []*models.Order, gen uint64) {
+	c.setLookup(c.byTrack, trackNumber, orders, gen)
+}
+
+// SetRid is SetTrack for the orders containing an item with the given rid.
+func (c *Cache) SetRid(rid string, orders []*models.Order, gen uint64) {
+	c.setLookup(c.byRid, rid, orders, gen)
+}
+
+func (c *Cache) findLookup(l *lookup, key string) ([]*models.Order, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	orders := c.find(l.uids, key)
+	_, complete := l.complete[key]
+	return orders, complete
+}
+
+func (c *Cache) setLookup(l *lookup, key string, orders []*models.Order, gen uint64) {
 	c.Lock()
 	defer c.Unlock()
 
+	if gen != c.lookupGen || key == "" {
+		return
+	}
 	now := time.Now()
-	for uid, expTime := range c.expirationTimes {
-		if now.After(expTime) {
-			delete(c.data, uid)
-			delete(c.expirationTimes, uid)
+	for _, order := range orders {
+		if order == nil || c.set(order, now) != nil {
+			return
 		}
 	}
+	// a small cache may have evicted some of them again
+	for _, order := range orders {
+		if _, ok := c.items[order.OrderUID]; !ok {
+			return
+		}
+	}
+	l.complete[key] = struct{}{}
 }
 
-func (c *Cache) copyOrder(order *models.Order) *models.Order {
-	if order == nil {
+// find must be called with the lock held.
+func (c *Cache) find(ix index, key string) []*models.Order {
+	uids := ix[key]
+	if len(uids) == 0 {
 		return nil
 	}
-	o := *order
-	if len(order.Items) > 0 {
-		items := make([]models.Item, len(order.Items))
-		copy(items, order.Items)
-		o.Items = items
-	}
-	return &o
-}
 
-func (c *Cache) startCleanupRoutine() {
-	cleanupInterval := c.ttl / 2
-	if cleanupInterval <= 0 {
-		cleanupInterval = time.Minute
+	now := time.Now()
+	var expired []*list.Element
+	orders := make([]*models.Order, 0, len(uids))
+	for uid := range uids {
+		elem := c.items[uid]
+		e := elem.Value.(*entry)
+		if c.expired(e, now) {
+			expired = append(expired, elem)
+			continue
+		}
+		c.lru.MoveToFront(elem)
+		orders = append(orders, c.copyOrder(e.order))
 	}
+	for _, elem := range expired {
+		c.removeElement(elem)
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].OrderUID < orders[j].OrderUID })
+	return orders
+}
 
-	ticker := time.NewTicker(cleanupInterval)
-	defer ticker.Stop()
+func (c *Cache) Cleanup() {
+	c.Lock()
+	defer c.Unlock()
 
-	for {
-		select {
-		case <-ticker.C:
-			c.Cleanup()
-		case <-c.stop:
-			return
+	now := time.Now()
+	for _, elem := range c.items {
+		if c.expired(elem.Value.(*entry), now) {
+			c.removeElement(elem)
 		}
 	}
 }
 
 func (c *Cache) Count() int {
-	c.RLock()
-	defer c.RUnlock()
+	c.Lock()
+	defer c.Unlock()
 
 	if c.ttl == 0 {
-		return len(c.data)
+		return len(c.items)
 	}
 
 	count := 0
 	now := time.Now()
-	for uid := range c.data {
-		if expTime, exists := c.expirationTimes[uid]; exists && now.Before(expTime) {
+	for _, elem := range c.items {
+		if !c.expired(elem.Value.(*entry), now) {
 			count++
 		}
 	}
@@ -166,20 +386,192 @@ func (c *Cache) Count() int {
 	return count
 }
 
+func (c *Cache) Bytes() int64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.bytes
+}
+
+func (c *Cache) Evictions() uint64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.evictions
+}
+
+func (c *Cache) Stats() Stats {
+	c.Lock()
+	defer c.Unlock()
+	return Stats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   len(c.items),
+		Bytes:     c.bytes,
+	}
+}
+
+// DBBackup fills the cache from a DB snapshot. Orders are expected newest
+// first, so they are inserted in reverse to keep the newest ones when the
+// cache is too small to hold all of them.
 func (c *Cache) DBBackup(orders []*models.Order) error {
 	c.Lock()
 	defer c.Unlock()
 
+	now := time.Now()
+	for i := len(orders) - 1; i >= 0; i-- {
+		if orders[i] == nil {
+			continue
+		}
+		_ = c.set(orders[i], now)
+	}
+	return nil
+}
+
+// Backfill appends orders at the cold end of the LRU without touching entries
+// that are already cached, so it never overwrites fresher data or evicts
+// anything. It reports how many orders were added and whether the cache is full.
+func (c *Cache) Backfill(orders []*models.Order) (int, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	added := 0
 	for _, order := range orders {
 		if order == nil {
 			continue
 		}
-		orderCopy := c.copyOrder(order)
-		c.data[order.OrderUID] = orderCopy
+		if _, ok := c.items[order.OrderUID]; ok {
+			continue
+		}
+		size := orderSize(order)
+		if (c.maxEntries > 0 && c.lru.Len() >= c.maxEntries) || (c.maxBytes > 0 && c.bytes+size > c.maxBytes) {
+			return added, true
+		}
 
+		e := &entry{order: c.copyOrder(order), size: size}
 		if c.ttl > 0 {
-			c.expirationTimes[order.OrderUID] = time.Now().Add(c.ttl)
+			e.expiresAt = now.Add(c.ttl)
+		}
+		c.items[order.OrderUID] = c.lru.PushBack(e)
+		c.bytes += size
+		c.indexOrder(e.order)
+		added++
+	}
+	return added, false
+}
+
+// set keeps a cached order newer than the one being set: a DB read can
+// finish after an update of the same order was cached.
+func (c *Cache) set(order *models.Order, now time.Time) error {
+	if elem, ok := c.items[order.OrderUID]; ok {
+		if old := elem.Value.(*entry); old.order.Version > order.Version && !c.expired(old, now) {
+			return nil
 		}
 	}
+
+	size := orderSize(order)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		// the cached copy is older than the order being set and must not be
+		// served anymore, and lookups of its keys miss it
+		if elem, ok := c.items[order.OrderUID]; ok {
+			c.removeElement(elem)
+		}
+		c.byTrack.forget(order)
+		c.byRid.forget(order)
+		c.lookupGen++
+		return errors.New("order exceeds cache memory budget")
+	}
+
+	e := &entry{order: c.copyOrder(order), size: size}
+	if c.ttl > 0 {
+		e.expiresAt = now.Add(c.ttl)
+	}
+
+	if elem, ok := c.items[order.OrderUID]; ok {
+		old := elem.Value.(*entry)
+		c.bytes += size - old.size
+		c.unindexOrder(old.order)
+		elem.Value = e
+		c.lru.MoveToFront(elem)
+	} else {
+		c.items[order.OrderUID] = c.lru.PushFront(e)
+		c.bytes += size
+	}
+	c.indexOrder(e.order)
+
+	c.evict()
 	return nil
 }
+
+func (c *Cache) evict() {
+	for c.lru.Len() > 0 &&
+		((c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes)) {
+		c.removeElement(c.lru.Back())
+		c.evictions++
+	}
+}
+
+func (c *Cache) removeElement(elem *list.Element) {
+	e := c.lru.Remove(elem).(*entry)
+	delete(c.items, e.order.OrderUID)
+	c.bytes -= e.size
+	c.unindexOrder(e.order)
+	c.byTrack.forget(e.order)
+	c.byRid.forget(e.order)
+}
+
+func (c *Cache) indexOrder(order *models.Order) {
+	c.byTransaction.add(order.Payment.Transaction, order.OrderUID)
+	c.byTrack.add(order)
+	c.byRid.add(order)
+}
+
+func (c *Cache) unindexOrder(order *models.Order) {
+	c.byTransaction.remove(order.Payment.Transaction, order.OrderUID)
+	c.byTrack.remove(order)
+	c.byRid.remove(order)
+}
+
+// invalidateLookups forgets which track and rid lookups are complete.
+func (c *Cache) invalidateLookups() {
+	clear(c.byTrack.complete)
+	clear(c.byRid.complete)
+	c.lookupGen++
+}
+
+func (c *Cache) expired(e *entry, now time.Time) bool {
+	return c.ttl > 0 && now.After(e.expiresAt)
+}
+
+func (c *Cache) copyOrder(order *models.Order) *models.Order {
+	if order == nil {
+		return nil
+	}
+	o := *order
+	o.RawPayload = nil
+	if len(order.Items) > 0 {
+		items := make([]models.Item, len(order.Items))
+		copy(items, order.Items)
+		o.Items = items
+	}
+	return &o
+}
+
+func (c *Cache) startCleanupRoutine() {
+	cleanupInterval := c.ttl / 2
+	if cleanupInterval <= 0 {
+		cleanupInterval = time.Minute
+	}
+
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Cleanup()
+		case <-c.stop:
+			return
+		}
+	}
+}
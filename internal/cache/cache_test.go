@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -56,3 +57,259 @@ func TestCache_CopyIsolation(t *testing.T) {
 	retrievedAgain, _, _ := cache.Get("test123")
 	assert.Equal(t, "ORIGINAL", retrievedAgain.TrackNumber)
 }
+
+func TestCache_EvictsLeastRecentlyUsedByCount(t *testing.T) {
+	cache := NewBounded(10*time.Minute, 2, 0)
+	defer cache.Close()
+
+	cache.Set(&models.Order{OrderUID: "a"})
+	cache.Set(&models.Order{OrderUID: "b"})
+
+	_, _, _ = cache.Get("a")
+	cache.Set(&models.Order{OrderUID: "c"})
+
+	_, existsA, _ := cache.Get("a")
+	_, existsB, _ := cache.Get("b")
+	_, existsC, _ := cache.Get("c")
+	assert.True(t, existsA)
+	assert.False(t, existsB)
+	assert.True(t, existsC)
+	assert.Equal(t, 2, cache.Count())
+	assert.Equal(t, uint64(1), cache.Evictions())
+}
+
+func TestCache_EvictsByMemoryBudget(t *testing.T) {
+	order := &models.Order{OrderUID: "a", TrackNumber: "TRACK"}
+	budget := orderSize(order) * 2
+
+	cache := NewBounded(0, 0, budget)
+	defer cache.Close()
+
+	cache.Set(order)
+	cache.Set(&models.Order{OrderUID: "b", TrackNumber: "TRACK"})
+	cache.Set(&models.Order{OrderUID: "c", TrackNumber: "TRACK"})
+
+	assert.Equal(t, 2, cache.Count())
+	assert.LessOrEqual(t, cache.Bytes(), budget)
+
+	_, existsA, _ := cache.Get("a")
+	assert.False(t, existsA)
+}
+
+func TestCache_OversizedSetDropsStaleVersion(t *testing.T) {
+	order := &models.Order{OrderUID: "a", TrackNumber: "TRACK", Payment: models.Payment{Transaction: "tx-a"}}
+	cache := NewBounded(0, 0, orderSize(order)+10)
+	defer cache.Close()
+
+	assert.NoError(t, cache.Set(order))
+
+	bigger := &models.Order{OrderUID: "a", TrackNumber: strings.Repeat("T", 100), Payment: models.Payment{Transaction: "tx-a"}}
+	assert.Error(t, cache.Set(bigger))
+
+	_, exists, _ := cache.Get("a")
+	assert.False(t, exists)
+	assert.Empty(t, cache.FindByTransaction("tx-a"))
+	assert.Equal(t, int64(0), cache.Bytes())
+}
+
+func TestCache_DBBackupKeepsNewestWhenBounded(t *testing.T) {
+	cache := NewBounded(0, 2, 0)
+	defer cache.Close()
+
+	orders := []*models.Order{{OrderUID: "newest"}, {OrderUID: "middle"}, {OrderUID: "oldest"}}
+	assert.NoError(t, cache.DBBackup(orders))
+
+	_, existsNewest, _ := cache.Get("newest")
+	_, existsOldest, _ := cache.Get("oldest")
+	assert.True(t, existsNewest)
+	assert.False(t, existsOldest)
+}
+
+func TestCache_DeleteReleasesBytes(t *testing.T) {
+	cache := New(0)
+	defer cache.Close()
+
+	cache.Set(&models.Order{OrderUID: "a", TrackNumber: "TRACK"})
+	assert.Greater(t, cache.Bytes(), int64(0))
+
+	cache.Delete("a")
+	assert.Equal(t, int64(0), cache.Bytes())
+}
+
+func TestCache_BackfillKeepsExistingAndStopsWhenFull(t *testing.T) {
+	cache := NewBounded(0, 2, 0)
+	defer cache.Close()
+
+	cache.Set(&models.Order{OrderUID: "a", TrackNumber: "FRESH"})
+
+	added, full := cache.Backfill([]*models.Order{
+		{OrderUID: "a", TrackNumber: "STALE"},
+		{OrderUID: "b"},
+		{OrderUID: "c"},
+	})
+	assert.Equal(t, 1, added)
+	assert.True(t, full)
+
+	a, _, _ := cache.Get("a")
+	assert.Equal(t, "FRESH", a.TrackNumber)
+	assert.Equal(t, uint64(0), cache.Evictions())
+}
+
+func TestCache_StatsCountsHitsAndMisses(t *testing.T) {
+	cache := New(10 * time.Minute)
+	defer cache.Close()
+
+	cache.Set(&models.Order{OrderUID: "a"})
+	_, _, _ = cache.Get("a")
+	_, _, _ = cache.Get("missing")
+
+	stats := cache.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, 1, stats.Entries)
+}
+
+func TestCache_DoesNotKeepRawPayload(t *testing.T) {
+	cache := New(0)
+	defer cache.Close()
+
+	cache.Set(&models.Order{OrderUID: "raw", RawPayload: []byte(`{"order_uid":"raw"}`)})
+
+	retrieved, exists, _ := cache.Get("raw")
+	assert.True(t, exists)
+	assert.Nil(t, retrieved.RawPayload)
+}
+
+func TestCache_EvictOlderKeepsCurrentVersion(t *testing.T) {
+	cache := New(0)
+	defer cache.Close()
+
+	cache.Set(&models.Order{OrderUID: "a", Version: 2})
+	cache.Set(&models.Order{OrderUID: "b", Version: 1})
+
+	cache.EvictOlder("a", 2)
+	cache.EvictOlder("b", 2)
+
+	_, exists, _ := cache.Get("a")
+	assert.True(t, exists)
+	_, exists, _ = cache.Get("b")
+	assert.False(t, exists)
+
+	cache.Clear()
+	assert.Equal(t, 0, cache.Count())
+	assert.Equal(t, int64(0), cache.Bytes())
+}
+
+func TestCache_SetKeepsNewerVersion(t *testing.T) {
+	cache := New(0)
+	defer cache.Close()
+
+	cache.Set(&models.Order{OrderUID: "a", Version: 3, TrackNumber: "new"})
+	assert.NoError(t, cache.Set(&models.Order{OrderUID: "a", Version: 2, TrackNumber: "stale"}))
+
+	got, exists, _ := cache.Get("a")
+	assert.True(t, exists)
+	assert.Equal(t, 3, got.Version)
+	assert.Equal(t, "new", got.TrackNumber)
+
+	cache.Set(&models.Order{OrderUID: "a", Version: 3, TrackNumber: "reloaded"})
+	got, _, _ = cache.Get("a")
+	assert.Equal(t, "reloaded", got.TrackNumber)
+}
+
+func TestCache_TransactionIndex(t *testing.T) {
+	cache := NewBounded(0, 2, 0)
+	defer cache.Close()
+
+	cache.Set(&models.Order{OrderUID: "a", Payment: models.Payment{Transaction: "tx-a"}})
+	cache.Set(&models.Order{OrderUID: "b", Payment: models.Payment{Transaction: "tx-b"}})
+
+	orders := cache.FindByTransaction("tx-b")
+	assert.Len(t, orders, 1)
+	assert.Equal(t, "b", orders[0].OrderUID)
+
+	// replacing an order re-indexes it
+	cache.Set(&models.Order{OrderUID: "a", Payment: models.Payment{Transaction: "tx-a2"}})
+	assert.Empty(t, cache.FindByTransaction("tx-a"))
+	assert.Len(t, cache.FindByTransaction("tx-a2"), 1)
+
+	// "b" is the least recently used one and gets evicted
+	cache.Set(&models.Order{OrderUID: "c"})
+	assert.Empty(t, cache.FindByTransaction("tx-b"))
+
+	cache.Clear()
+	assert.Empty(t, cache.FindByTransaction("tx-a2"))
+}
+
+func TestCache_TrackAndRidLookups(t *testing.T) {
+	cache := NewBounded(0, 3, 0)
+	defer cache.Close()
+
+	a := &models.Order{OrderUID: "a", TrackNumber: "T1", Items: []models.Item{{Rid: "r1"}, {Rid: "r2"}}}
+	b := &models.Order{OrderUID: "b", TrackNumber: "T1"}
+
+	// orders cached one by one don't make a track complete
+	cache.Set(a)
+	orders, complete := cache.FindByTrack("T1")
+	assert.Len(t, orders, 1)
+	assert.False(t, complete)
+
+	cache.SetTrack("T1", []*models.Order{a, b}, cache.LookupGen())
+	orders, complete = cache.FindByTrack("T1")
+	assert.True(t, complete)
+	assert.Equal(t, "a", orders[0].OrderUID)
+	assert.Equal(t, "b", orders[1].OrderUID)
+	orders, complete = cache.FindByRid("r2")
+	assert.Len(t, orders, 1)
+	assert.False(t, complete)
+
+	// an order written here joins a complete track
+	cache.Set(&models.Order{OrderUID: "c", TrackNumber: "T1"})
+	orders, complete = cache.FindByTrack("T1")
+	assert.Len(t, orders, 3)
+	assert.True(t, complete)
+
+	// replacing an order re-indexes it; the track stays complete without it
+	cache.Set(&models.Order{OrderUID: "a", TrackNumber: "T2", Items: []models.Item{{Rid: "r3"}}})
+	orders, complete = cache.FindByTrack("T1")
+	assert.Len(t, orders, 2)
+	assert.True(t, complete)
+	orders, _ = cache.FindByRid("r1")
+	assert.Empty(t, orders)
+
+	// an evicted order leaves its track incomplete
+	cache.Get("a")
+	cache.Set(&models.Order{OrderUID: "d"})
+	_, complete = cache.FindByTrack("T1")
+	assert.False(t, complete)
+
+	cache.Clear()
+	orders, _ = cache.FindByRid("r3")
+	assert.Empty(t, orders)
+}
+
+func TestCache_LookupsAreIncompleteAfterForeignWrites(t *testing.T) {
+	cache := New(0)
+	defer cache.Close()
+
+	a := &models.Order{OrderUID: "a", TrackNumber: "T1", Version: 1}
+	cache.SetTrack("T1", []*models.Order{a}, cache.LookupGen())
+
+	// the notification of this instance's own write changes nothing
+	cache.EvictOlder("a", 1)
+	_, complete := cache.FindByTrack("T1")
+	assert.True(t, complete)
+
+	// an order written elsewhere may have any track
+	cache.EvictOlder("x", 1)
+	_, complete = cache.FindByTrack("T1")
+	assert.False(t, complete)
+
+	// a load that started before a foreign write caches nothing
+	gen := cache.LookupGen()
+	cache.Delete("a")
+	cache.SetTrack("T1", []*models.Order{a}, gen)
+	orders, complete := cache.FindByTrack("T1")
+	assert.Empty(t, orders)
+	assert.False(t, complete)
+}
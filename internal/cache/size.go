@@ -0,0 +1,31 @@
+package cache
+
+import (
+	"unsafe"
+
+	"wb-tech-1task/internal/models"
+)
+
+// entryOverhead roughly covers the map slot, list element and entry struct
+// that back every cached order.
+const entryOverhead = 160
+
+// orderSize estimates the heap footprint of an order. It is not exact, but
+// it grows with the data actually stored, which is what the budget needs.
+func orderSize(o *models.Order) int64 {
+	size := int64(entryOverhead) + int64(unsafe.Sizeof(*o))
+	size += int64(len(o.OrderUID) + len(o.TrackNumber) + len(o.Entry) + len(o.Locale) +
+		len(o.InternalSignature) + len(o.CustomerID) + len(o.DeliveryService) + len(o.Shardkey) + len(o.OofShard))
+
+	d := o.Delivery
+	size += int64(len(d.Name) + len(d.Phone) + len(d.Zip) + len(d.City) + len(d.Address) + len(d.Region) + len(d.Email))
+
+	p := o.Payment
+	size += int64(len(p.OrderUID) + len(p.Transaction) + len(p.RequestID) + len(p.Currency) + len(p.Provider) + len(p.Bank))
+
+	size += int64(len(o.Items)) * int64(unsafe.Sizeof(models.Item{}))
+	for _, it := range o.Items {
+		size += int64(len(it.TrackNumber) + len(it.Rid) + len(it.Name) + len(it.Size) + len(it.Brand))
+	}
+	return size
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,6 +15,39 @@ type Config struct {
 	KafkaGroup   string
 	HTTPAddr     string
 	CacheTTL     time.Duration
+
+	CacheMaxEntries int
+	CacheMaxBytes   int64
+
+	CacheWarmupBatch int
+	CacheWarmupLimit int
+
+	CacheInvalidation bool
+	CacheNegativeTTL  time.Duration
+
+	KafkaRetryAttempts int
+	KafkaRetryBackoff  time.Duration
+	KafkaRetryTiers    []string
+
+	KafkaWorkers        int
+	KafkaCommitInterval time.Duration
+	KafkaCommitBatch    int
+	KafkaBatchSize      int
+
+	OutboxTopic        string
+	OutboxPollInterval time.Duration
+	OutboxBatch        int
+
+	StatsCacheTTL time.Duration
+
+	// ProcessedRetention is how long idempotency keys are kept; zero keeps
+	// them forever.
+	ProcessedRetention time.Duration
+
+	// OrderRules holds business rule modes as "rule=mode" pairs.
+	OrderRules []string
+
+	AdminToken string
 }
 
 func LoadFromEnv() (*Config, error) {
@@ -56,6 +90,142 @@ func LoadFromEnv() (*Config, error) {
 		}
 	}
 
+	cacheMaxEntries := 100000
+	if v := os.Getenv("CACHE_MAX_ENTRIES"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+			cacheMaxEntries = parsed
+		}
+	}
+
+	cacheMaxMB := 256
+	if v := os.Getenv("CACHE_MAX_MB"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+			cacheMaxMB = parsed
+		}
+	}
+
+	warmupBatch := 500
+	if v := os.Getenv("CACHE_WARMUP_BATCH"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			warmupBatch = parsed
+		}
+	}
+
+	warmupLimit := 0
+	if v := os.Getenv("CACHE_WARMUP_LIMIT"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+			warmupLimit = parsed
+		}
+	}
+
+	cacheInvalidation := true
+	if v := os.Getenv("CACHE_INVALIDATION"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			cacheInvalidation = parsed
+		}
+	}
+
+	negativeTTLSec := 5
+	if v := os.Getenv("CACHE_NEGATIVE_TTL_SECONDS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+			negativeTTLSec = parsed
+		}
+	}
+
+	retryAttempts := 3
+	if v := os.Getenv("KAFKA_RETRY_ATTEMPTS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			retryAttempts = parsed
+		}
+	}
+
+	retryBackoffMs := 200
+	if v := os.Getenv("KAFKA_RETRY_BACKOFF_MS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+			retryBackoffMs = parsed
+		}
+	}
+
+	retryTiers := []string{"5s", "1m"}
+	if v, ok := os.LookupEnv("KAFKA_RETRY_TIERS"); ok {
+		retryTiers = nil
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				retryTiers = append(retryTiers, t)
+			}
+		}
+	}
+
+	kafkaWorkers := 8
+	if v := os.Getenv("KAFKA_WORKERS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			kafkaWorkers = parsed
+		}
+	}
+
+	commitIntervalMs := 1000
+	if v := os.Getenv("KAFKA_COMMIT_INTERVAL_MS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			commitIntervalMs = parsed
+		}
+	}
+
+	commitBatch := 100
+	if v := os.Getenv("KAFKA_COMMIT_BATCH"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+			commitBatch = parsed
+		}
+	}
+
+	kafkaBatchSize := 100
+	if v := os.Getenv("KAFKA_BATCH_SIZE"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			kafkaBatchSize = parsed
+		}
+	}
+
+	outboxTopic := "order_events"
+	if v, ok := os.LookupEnv("OUTBOX_TOPIC"); ok {
+		outboxTopic = strings.TrimSpace(v)
+	}
+
+	outboxPollMs := 500
+	if v := os.Getenv("OUTBOX_POLL_INTERVAL_MS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			outboxPollMs = parsed
+		}
+	}
+
+	outboxBatch := 100
+	if v := os.Getenv("OUTBOX_BATCH"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			outboxBatch = parsed
+		}
+	}
+
+	statsCacheSec := 60
+	if v := os.Getenv("STATS_CACHE_SECONDS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+			statsCacheSec = parsed
+		}
+	}
+
+	processedRetentionHours := 168
+	if v := os.Getenv("PROCESSED_RETENTION_HOURS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+			processedRetentionHours = parsed
+		}
+	}
+
+	var orderRules []string
+	if v := os.Getenv("ORDER_RULES"); v != "" {
+		for _, r := range strings.Split(v, ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				orderRules = append(orderRules, r)
+			}
+		}
+	}
+
 	return &Config{
 		DatabaseURL:  dsn,
 		KafkaBrokers: []string{kafkaBrokers},
@@ -63,5 +233,35 @@ func LoadFromEnv() (*Config, error) {
 		KafkaGroup:   kafkaGroup,
 		HTTPAddr:     httpAddr,
 		CacheTTL:     time.Duration(ttlSec) * time.Second,
+
+		CacheMaxEntries: cacheMaxEntries,
+		CacheMaxBytes:   int64(cacheMaxMB) << 20,
+
+		CacheWarmupBatch: warmupBatch,
+		CacheWarmupLimit: warmupLimit,
+
+		CacheInvalidation: cacheInvalidation,
+		CacheNegativeTTL:  time.Duration(negativeTTLSec) * time.Second,
+
+		KafkaRetryAttempts: retryAttempts,
+		KafkaRetryBackoff:  time.Duration(retryBackoffMs) * time.Millisecond,
+		KafkaRetryTiers:    retryTiers,
+
+		KafkaWorkers:        kafkaWorkers,
+		KafkaCommitInterval: time.Duration(commitIntervalMs) * time.Millisecond,
+		KafkaCommitBatch:    commitBatch,
+		KafkaBatchSize:      kafkaBatchSize,
+
+		OutboxTopic:        outboxTopic,
+		OutboxPollInterval: time.Duration(outboxPollMs) * time.Millisecond,
+		OutboxBatch:        outboxBatch,
+
+		StatsCacheTTL: time.Duration(statsCacheSec) * time.Second,
+
+		ProcessedRetention: time.Duration(processedRetentionHours) * time.Hour,
+
+		OrderRules: orderRules,
+
+		AdminToken: os.Getenv("ADMIN_TOKEN"),
 	}, nil
 }
@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"wb-tech-1task/internal/models"
+)
+
+// UpsertOrders creates or replaces every order in one transaction. Each order
+// is written under a savepoint, so an order rejected by a constraint or a
+// column type is rolled back alone and reported as invalid, and an order
+// created concurrently by someone else is retried as a replacement. Any other error
+// aborts the whole transaction. Results are in the order of orders; Index is
+// left for the caller.
+func (r *PostgresRepository) UpsertOrders(ctx context.Context, orders []*models.Order) ([]models.BatchResult, error) {
+	qctx, cancel := ctxWithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(qctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]models.BatchResult, len(orders))
+	for i, order := range orders {
+		results[i].OrderUID = order.OrderUID
+		if _, err := tx.ExecContext(qctx, `SAVEPOINT upsert_order`); err != nil {
+			return nil, err
+		}
+
+		status, version, err := r.upsertOrder(qctx, tx, order)
+		if isOrderExists(err) {
+			// A concurrent create of the same order committed after the
+			// lookup found no row; it is visible now, so replace it.
+			if _, err := tx.ExecContext(qctx, `ROLLBACK TO SAVEPOINT upsert_order`); err != nil {
+				return nil, err
+			}
+			status, version, err = r.upsertOrder(qctx, tx, order)
+		}
+		if err != nil {
+			var pqErr *pq.Error
+			if !errors.As(err, &pqErr) || !isDataError(pqErr) {
+				return nil, err
+			}
+			if _, err := tx.ExecContext(qctx, `ROLLBACK TO SAVEPOINT upsert_order`); err != nil {
+				return nil, err
+			}
+			results[i].Status = models.BatchInvalid
+			results[i].Error = pqErr.Message
+			continue
+		}
+
+		if _, err := tx.ExecContext(qctx, `RELEASE SAVEPOINT upsert_order`); err != nil {
+			return nil, err
+		}
+		results[i].Status = status
+		results[i].Version = version
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for i, order := range orders {
+		if results[i].Status != models.BatchInvalid {
+			order.Version = results[i].Version
+		}
+	}
+	return results, nil
+}
+
+func (r *PostgresRepository) upsertOrder(ctx context.Context, tx *sql.Tx, order *models.Order) (string, int, error) {
+	var current int
+	err := tx.QueryRowContext(ctx, `SELECT version FROM orders WHERE order_uid = $1 FOR UPDATE`, order.OrderUID).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		version, err := insertOrder(ctx, tx, order)
+		if err != nil {
+			return "", 0, err
+		}
+		return models.BatchCreated, version, recordChange(ctx, tx, order, version, models.ChangeCreate)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	version, err := r.replaceOrder(ctx, tx, order, current)
+	if err != nil {
+		return "", 0, err
+	}
+	return models.BatchUpdated, version, recordChange(ctx, tx, order, version, models.ChangeUpdate)
+}
+
+// isDataError tells whether the DB rejected the data itself, e.g. a duplicate
+// transaction or a too long value, as opposed to failing. A duplicate
+// order_uid is not bad data but a lost race with another create.
+func isDataError(err *pq.Error) bool {
+	class := err.Code.Class()
+	return class == "22" || class == "23" && !isOrderExists(err)
+}
+
+// isOrderExists tells whether err is the unique violation of an order insert
+// racing a concurrent create of the same order_uid.
+func isOrderExists(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "orders_pkey"
+}
@@ -0,0 +1,25 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsDataError(t *testing.T) {
+	assert.True(t, isDataError(&pq.Error{Code: "23505", Constraint: "payment_transaction_key"})) // unique_violation
+	assert.True(t, isDataError(&pq.Error{Code: "23514"}))                                        // check_violation
+	assert.False(t, isDataError(&pq.Error{Code: "23505", Constraint: "orders_pkey"}))            // concurrent create
+	assert.True(t, isDataError(&pq.Error{Code: "22001"}))                                        // string_data_right_truncation
+	assert.False(t, isDataError(&pq.Error{Code: "40001"}))                                       // serialization_failure
+	assert.False(t, isDataError(&pq.Error{Code: "57014"}))                                       // query_canceled
+}
+
+func TestInsertItemsSQL(t *testing.T) {
+	sql := insertItemsSQL(2)
+	assert.Contains(t, sql, "VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12),($13,")
+	assert.True(t, strings.HasSuffix(sql, ",$24)"))
+	assert.LessOrEqual(t, maxItemsPerInsert*itemColumns, 65535)
+}
@@ -0,0 +1,61 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"wb-tech-1task/internal/models"
+)
+
+const favouriteBrandsLimit = 5
+
+// GetCustomerSummary aggregates the customer's orders in one query. A customer
+// without orders gets a summary with OrderCount 0.
+func (r *PostgresRepository) GetCustomerSummary(ctx context.Context, customerID string) (*models.CustomerSummary, error) {
+	qctx, cancel := ctxWithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var (
+		first, last   sql.NullTime
+		spent, brands []byte
+	)
+	summary := &models.CustomerSummary{CustomerID: customerID}
+	err := r.db.QueryRowContext(qctx, `
+WITH c AS (
+	SELECT order_uid, date_created FROM orders WHERE customer_id = $1
+)
+SELECT
+	(SELECT count(*) FROM c),
+	(SELECT min(date_created) FROM c),
+	(SELECT max(date_created) FROM c),
+	(SELECT COALESCE(json_object_agg(currency, total), '{}')
+	 FROM (
+		SELECT p.currency, sum(p.amount) AS total
+		FROM payment p JOIN c ON c.order_uid = p.order_uid
+		GROUP BY p.currency
+	 ) s),
+	(SELECT COALESCE(json_agg(json_build_object('brand', brand, 'items', n) ORDER BY n DESC, brand), '[]')
+	 FROM (
+		SELECT i.brand, count(*) AS n
+		FROM items i JOIN c ON c.order_uid = i.order_uid
+		GROUP BY i.brand
+		ORDER BY n DESC, i.brand
+		LIMIT $2
+	 ) b);
+`, customerID, favouriteBrandsLimit).Scan(&summary.OrderCount, &first, &last, &spent, &brands)
+	if err != nil {
+		return nil, err
+	}
+
+	summary.FirstOrderAt = first.Time
+	summary.LastOrderAt = last.Time
+	if err := json.Unmarshal(spent, &summary.TotalSpent); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(brands, &summary.FavouriteBrands); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
@@ -0,0 +1,104 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+
+	"wb-tech-1task/internal/models"
+)
+
+// OrderChangesChannel carries a notification for every committed order
+// change, sent by recordChange inside the change's transaction.
+const OrderChangesChannel = "order_changes"
+
+type orderChange struct {
+	OrderUID  string `json:"order_uid"`
+	Version   int    `json:"version"`
+	Operation string `json:"operation"`
+}
+
+// Invalidator is the local cache as seen by the ChangeListener.
+type Invalidator interface {
+	EvictOlder(orderUID string, version int)
+	Delete(orderUID string)
+	Clear()
+}
+
+// NotFoundForgetter is the memory of orders looked up and not found, which
+// must forget an order as soon as any instance writes it.
+type NotFoundForgetter interface {
+	ForgetNotFound(orderUID string)
+	ClearNotFound()
+}
+
+// ChangeListener keeps the local cache coherent with writes made by other
+// instances by listening on OrderChangesChannel.
+type ChangeListener struct {
+	dsn      string
+	cache    Invalidator
+	notFound NotFoundForgetter
+	logger   *zap.Logger
+}
+
+// NewChangeListener invalidates cache and, if not nil, notFound on every
+// order change.
+func NewChangeListener(dsn string, cache Invalidator, notFound NotFoundForgetter, logger *zap.Logger) *ChangeListener {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &ChangeListener{dsn: dsn, cache: cache, notFound: notFound, logger: logger}
+}
+
+func (l *ChangeListener) Run(ctx context.Context) error {
+	listener := pq.NewListener(l.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			l.logger.Warn("order changes listener connection event", zap.Error(err))
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(OrderChangesChannel); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			l.handle(n)
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+		}
+	}
+}
+
+func (l *ChangeListener) handle(n *pq.Notification) {
+	if n == nil {
+		// the connection was re-established and notifications sent in
+		// between are lost, so nothing cached can be trusted anymore
+		l.cache.Clear()
+		if l.notFound != nil {
+			l.notFound.ClearNotFound()
+		}
+		return
+	}
+
+	var ch orderChange
+	if err := json.Unmarshal([]byte(n.Extra), &ch); err != nil || ch.OrderUID == "" {
+		l.logger.Warn("order changes listener got a bad payload", zap.String("payload", n.Extra), zap.Error(err))
+		return
+	}
+	if l.notFound != nil {
+		l.notFound.ForgetNotFound(ch.OrderUID)
+	}
+	if ch.Operation == models.ChangeDelete {
+		l.cache.Delete(ch.OrderUID)
+		return
+	}
+	l.cache.EvictOlder(ch.OrderUID, ch.Version)
+}
@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type fakeInvalidator struct {
+	evicted map[string]int
+	deleted []string
+	cleared bool
+}
+
+func (f *fakeInvalidator) EvictOlder(orderUID string, version int) { f.evicted[orderUID] = version }
+func (f *fakeInvalidator) Delete(orderUID string)                  { f.deleted = append(f.deleted, orderUID) }
+func (f *fakeInvalidator) Clear()                                  { f.cleared = true }
+
+type fakeNotFound struct {
+	forgotten []string
+	cleared   bool
+}
+
+func (f *fakeNotFound) ForgetNotFound(orderUID string) { f.forgotten = append(f.forgotten, orderUID) }
+func (f *fakeNotFound) ClearNotFound()                 { f.cleared = true }
+
+func TestChangeListener_Handle(t *testing.T) {
+	inv := &fakeInvalidator{evicted: map[string]int{}}
+	nf := &fakeNotFound{}
+	l := NewChangeListener("", inv, nf, zap.NewNop())
+
+	l.handle(&pq.Notification{Extra: `{"order_uid":"a","version":3,"operation":"update"}`})
+	l.handle(&pq.Notification{Extra: `{"order_uid":"b","version":1,"operation":"delete"}`})
+	l.handle(&pq.Notification{Extra: `not json`})
+
+	assert.Equal(t, map[string]int{"a": 3}, inv.evicted)
+	assert.Equal(t, []string{"b"}, inv.deleted)
+	assert.False(t, inv.cleared)
+	assert.Equal(t, []string{"a", "b"}, nf.forgotten)
+
+	l.handle(nil)
+	assert.True(t, inv.cleared)
+	assert.True(t, nf.cleared)
+}
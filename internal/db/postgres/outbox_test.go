@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestClaimOutbox_ConcurrentClaimersKeepOrderSequence needs a disposable
+// database with the migrations applied in TEST_DATABASE_URL.
+func TestClaimOutbox_ConcurrentClaimersKeepOrderSequence(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+	repo := &PostgresRepository{db: db}
+	ctx := context.Background()
+
+	uid := fmt.Sprintf("outbox-test-%d", time.Now().UnixNano())
+	var first, second int64
+	require.NoError(t, db.QueryRowContext(ctx,
+		`INSERT INTO outbox (event_type, order_uid, version) VALUES ('order.created', $1, 1) RETURNING id`, uid).Scan(&first))
+	require.NoError(t, db.QueryRowContext(ctx,
+		`INSERT INTO outbox (event_type, order_uid, version) VALUES ('order.updated', $1, 2) RETURNING id`, uid).Scan(&second))
+	t.Cleanup(func() { db.Exec(`DELETE FROM outbox WHERE order_uid = $1`, uid) })
+
+	claimed := func(ids []int64) []int64 {
+		var out []int64
+		for _, id := range ids {
+			if id == first || id == second {
+				out = append(out, id)
+			}
+		}
+		return out
+	}
+	claimByRepo := func() []int64 {
+		events, err := repo.ClaimOutbox(ctx, 1000, time.Minute)
+		require.NoError(t, err)
+		ids := make([]int64, len(events))
+		for i, ev := range events {
+			ids[i] = ev.ID
+		}
+		return claimed(ids)
+	}
+
+	// relay A has leased the first event but not committed its claim yet
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, claimOutboxSQL, 1000, 60.0)
+	require.NoError(t, err)
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var evType, orderUID string
+		var version int
+		var payload sql.NullString
+		var createdAt time.Time
+		require.NoError(t, rows.Scan(&id, &evType, &orderUID, &version, &payload, &createdAt))
+		ids = append(ids, id)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []int64{first}, claimed(ids))
+
+	// relay B claims meanwhile and must not get ahead of A
+	assert.Empty(t, claimByRepo())
+
+	require.NoError(t, tx.Commit())
+	assert.Empty(t, claimByRepo())
+
+	// once A published the first event, the second one is next
+	require.NoError(t, repo.DeleteOutbox(ctx, []int64{first}))
+	assert.Equal(t, []int64{second}, claimByRepo())
+}
@@ -6,6 +6,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 	"wb-tech-1task/internal/service"
 
@@ -82,6 +85,14 @@ func (r *PostgresRepository) Close() error {
 	return r.db.Close()
 }
 
+func (r *PostgresRepository) DB() *sql.DB {
+	return r.db
+}
+
+func (r *PostgresRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
 func (r *PostgresRepository) GetOrder(ctx context.Context, orderUID string) (*models.Order, error) {
 	qctx, cancel := ctxWithTimeout(ctx, 5*time.Second)
 	defer cancel()
@@ -97,16 +108,23 @@ func (r *PostgresRepository) GetOrder(ctx context.Context, orderUID string) (*mo
 
 	row := tx.QueryRowContext(qctx, `
 SELECT order_uid, track_number, entry, locale, internal_signature,
-customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard
+customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, version, warnings
 FROM orders WHERE order_uid = $1`, orderUID)
 
+	var warnings []byte
 	if err := row.Scan(&order.OrderUID, &order.TrackNumber, &order.Entry, &order.Locale, &order.InternalSignature,
-		&order.CustomerID, &order.DeliveryService, &order.Shardkey, &order.SmID, &dateCreated, &order.OofShard); err != nil {
+		&order.CustomerID, &order.DeliveryService, &order.Shardkey, &order.SmID, &dateCreated, &order.OofShard,
+		&order.Version, &warnings); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, service.ErrOrderNotFound
 		}
 		return nil, err
 	}
+	if len(warnings) > 0 {
+		if err := json.Unmarshal(warnings, &order.Warnings); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal order warnings: %w", err)
+		}
+	}
 	order.DateCreated = dateCreated
 
 	d := &models.Delivery{}
@@ -163,6 +181,55 @@ FROM items WHERE order_uid = $1 ORDER BY id`, orderUID)
 	return order, nil
 }
 
+// GetOrderHistory returns the change log of an order, oldest first.
+func (r *PostgresRepository) GetOrderHistory(ctx context.Context, orderUID string) ([]models.OrderRevision, error) {
+	qctx, cancel := ctxWithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(qctx, `
+SELECT version, operation, snapshot, source, source_ref, changed_at
+FROM order_history WHERE order_uid = $1 ORDER BY id`, orderUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []models.OrderRevision
+	for rows.Next() {
+		var rev models.OrderRevision
+		var snapshot, source, sourceRef sql.NullString
+		if err := rows.Scan(&rev.Version, &rev.Operation, &snapshot, &source, &sourceRef, &rev.ChangedAt); err != nil {
+			return nil, err
+		}
+		if snapshot.Valid {
+			rev.Snapshot = json.RawMessage(snapshot.String)
+		}
+		rev.Source, rev.SourceRef = source.String, sourceRef.String
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+func (r *PostgresRepository) GetRawPayload(ctx context.Context, orderUID string) ([]byte, error) {
+	qctx, cancel := ctxWithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var raw []byte
+	err := r.db.QueryRowContext(qctx, `SELECT raw_payload FROM orders WHERE order_uid = $1`, orderUID).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, service.ErrRawNotStored
+	}
+	return raw, nil
+}
+
+// SaveOrder inserts a new order. A second save of the same order_uid (or
+// payment transaction) fails with a unique violation instead of overwriting.
 func (r *PostgresRepository) SaveOrder(ctx context.Context, order *models.Order) error {
 	qctx, cancel := ctxWithTimeout(ctx, 8*time.Second)
 	defer cancel()
@@ -172,92 +239,495 @@ func (r *PostgresRepository) SaveOrder(ctx context.Context, order *models.Order)
 		return err
 	}
 	defer tx.Rollback()
+	if err := markProcessed(qctx, tx, order.OrderUID); err != nil {
+		return err
+	}
+
+	version, err := insertOrder(qctx, tx, order)
+	if err != nil {
+		return err
+	}
+	if err := recordChange(qctx, tx, order, version, models.ChangeCreate); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	order.Version = version
+	return nil
+}
+
+// SaveOrders creates all orders in one transaction; if any of them fails,
+// none is saved. Each order is written with its own change source from
+// models.OrderSourceFrom, so a batch of Kafka messages keeps per-message
+// deduplication.
+func (r *PostgresRepository) SaveOrders(ctx context.Context, orders []*models.Order) error {
+	qctx, cancel := ctxWithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(qctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	versions := make([]int, len(orders))
+	for i, order := range orders {
+		octx := models.WithChangeSource(qctx, models.OrderSourceFrom(qctx, order.OrderUID))
+		if err := markProcessed(octx, tx, order.OrderUID); err != nil {
+			return err
+		}
+		if versions[i], err = insertOrder(octx, tx, order); err != nil {
+			return err
+		}
+		if err := recordChange(octx, tx, order, versions[i], models.ChangeCreate); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for i, order := range orders {
+		order.Version = versions[i]
+	}
+	return nil
+}
 
-	_, err = tx.ExecContext(qctx, `
+// UpdateOrder replaces an existing order. With a non-zero expectedVersion
+// the update only applies if the stored version still matches.
+func (r *PostgresRepository) UpdateOrder(ctx context.Context, order *models.Order, expectedVersion int) error {
+	qctx, cancel := ctxWithTimeout(ctx, 8*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(qctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := markProcessed(qctx, tx, order.OrderUID); err != nil {
+		return err
+	}
+
+	version, err := r.replaceOrder(qctx, tx, order, expectedVersion)
+	if err != nil {
+		return err
+	}
+	if err := recordChange(qctx, tx, order, version, models.ChangeUpdate); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	order.Version = version
+	return nil
+}
+
+// insertOrder writes a new order with its delivery, payment and items and
+// returns its version.
+func insertOrder(ctx context.Context, tx *sql.Tx, order *models.Order) (int, error) {
+	var version int
+	err := tx.QueryRowContext(ctx, `
 INSERT INTO orders (order_uid, track_number, entry, locale, internal_signature,
-customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard)
-VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
-ON CONFLICT (order_uid) DO UPDATE SET
-track_number = EXCLUDED.track_number,
-entry = EXCLUDED.entry,
-locale = EXCLUDED.locale,
-internal_signature = EXCLUDED.internal_signature,
-customer_id = EXCLUDED.customer_id,
-delivery_service = EXCLUDED.delivery_service,
-shardkey = EXCLUDED.shardkey,
-sm_id = EXCLUDED.sm_id,
-date_created = EXCLUDED.date_created,
-oof_shard = EXCLUDED.oof_shard
+customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, raw_payload, warnings)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+RETURNING version
 `, order.OrderUID, order.TrackNumber, order.Entry, order.Locale, order.InternalSignature,
-		order.CustomerID, order.DeliveryService, order.Shardkey, order.SmID, order.DateCreated, order.OofShard)
+		order.CustomerID, order.DeliveryService, order.Shardkey, order.SmID, order.DateCreated, order.OofShard,
+		rawPayloadArg(order.RawPayload), warningsArg(order.Warnings)).Scan(&version)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	_, err = tx.ExecContext(qctx, `
+	_, err = tx.ExecContext(ctx, `
 INSERT INTO delivery (order_uid, name, phone, zip, city, address, region, email)
 VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
-ON CONFLICT (order_uid) DO UPDATE SET
-name = EXCLUDED.name,
-phone = EXCLUDED.phone,
-zip = EXCLUDED.zip,
-city = EXCLUDED.city,
-address = EXCLUDED.address,
-region = EXCLUDED.region,
-email = EXCLUDED.email
 `, order.OrderUID, order.Delivery.Name, order.Delivery.Phone, order.Delivery.Zip, order.Delivery.City,
 		order.Delivery.Address, order.Delivery.Region, order.Delivery.Email)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	_, err = tx.ExecContext(qctx, `
+	_, err = tx.ExecContext(ctx, `
 INSERT INTO payment (order_uid, transaction, request_id, currency, provider, amount, payment_dt, bank, delivery_cost, goods_total, custom_fee)
 VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
-ON CONFLICT (transaction) DO UPDATE SET
-request_id = EXCLUDED.request_id,
-currency = EXCLUDED.currency,
-provider = EXCLUDED.provider,
-amount = EXCLUDED.amount,
-payment_dt = EXCLUDED.payment_dt,
-bank = EXCLUDED.bank,
-delivery_cost = EXCLUDED.delivery_cost,
-goods_total = EXCLUDED.goods_total,
-custom_fee = EXCLUDED.custom_fee,
-order_uid = EXCLUDED.order_uid
 `, order.OrderUID, order.Payment.Transaction, order.Payment.RequestID, order.Payment.Currency, order.Payment.Provider,
 		order.Payment.Amount, order.Payment.PaymentDt, order.Payment.Bank, order.Payment.DeliveryCost, order.Payment.GoodsTotal,
 		order.Payment.CustomFee)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := insertItems(ctx, tx, order); err != nil {
+		return 0, err
+	}
+	if err := refreshSearch(ctx, tx, order.OrderUID); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// replaceOrder overwrites an existing order with its delivery, payment and
+// items and returns the new version.
+func (r *PostgresRepository) replaceOrder(ctx context.Context, tx *sql.Tx, order *models.Order, expectedVersion int) (int, error) {
+	var version int
+	err := tx.QueryRowContext(ctx, `
+UPDATE orders SET
+track_number = $2,
+entry = $3,
+locale = $4,
+internal_signature = $5,
+customer_id = $6,
+delivery_service = $7,
+shardkey = $8,
+sm_id = $9,
+date_created = $10,
+oof_shard = $11,
+raw_payload = COALESCE($13::bytea, raw_payload),
+warnings = $14::jsonb,
+version = version + 1,
+updated_at = now()
+WHERE order_uid = $1 AND ($12 = 0 OR version = $12)
+RETURNING version
+`, order.OrderUID, order.TrackNumber, order.Entry, order.Locale, order.InternalSignature,
+		order.CustomerID, order.DeliveryService, order.Shardkey, order.SmID, order.DateCreated, order.OofShard,
+		expectedVersion, rawPayloadArg(order.RawPayload), warningsArg(order.Warnings)).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, r.missingOrConflict(ctx, tx, order.OrderUID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+UPDATE delivery SET
+name = $2,
+phone = $3,
+zip = $4,
+city = $5,
+address = $6,
+region = $7,
+email = $8
+WHERE order_uid = $1
+`, order.OrderUID, order.Delivery.Name, order.Delivery.Phone, order.Delivery.Zip, order.Delivery.City,
+		order.Delivery.Address, order.Delivery.Region, order.Delivery.Email)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+UPDATE payment SET
+transaction = $2,
+request_id = $3,
+currency = $4,
+provider = $5,
+amount = $6,
+payment_dt = $7,
+bank = $8,
+delivery_cost = $9,
+goods_total = $10,
+custom_fee = $11
+WHERE order_uid = $1
+`, order.OrderUID, order.Payment.Transaction, order.Payment.RequestID, order.Payment.Currency, order.Payment.Provider,
+		order.Payment.Amount, order.Payment.PaymentDt, order.Payment.Bank, order.Payment.DeliveryCost, order.Payment.GoodsTotal,
+		order.Payment.CustomFee)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM items WHERE order_uid = $1", order.OrderUID); err != nil {
+		return 0, err
+	}
+	if err := insertItems(ctx, tx, order); err != nil {
+		return 0, err
+	}
+	if err := refreshSearch(ctx, tx, order.OrderUID); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+func (r *PostgresRepository) DeleteOrder(ctx context.Context, orderUID string, expectedVersion int) error {
+	qctx, cancel := ctxWithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(qctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+	if err := markProcessed(qctx, tx, orderUID); err != nil {
+		return err
+	}
 
-	if _, err := tx.ExecContext(qctx, "DELETE FROM items WHERE order_uid = $1", order.OrderUID); err != nil {
+	var version int
+	err = tx.QueryRowContext(qctx, `
+DELETE FROM orders WHERE order_uid = $1 AND ($2 = 0 OR version = $2)
+RETURNING version`, orderUID, expectedVersion).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r.missingOrConflict(qctx, tx, orderUID)
+	}
+	if err != nil {
 		return err
 	}
+	if err := recordChange(qctx, tx, &models.Order{OrderUID: orderUID}, version, models.ChangeDelete); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
-	insertItemSQL := `
-INSERT INTO items (order_uid, chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status)
-VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
-`
-	for _, it := range order.Items {
-		if _, err := tx.ExecContext(qctx, insertItemSQL, order.OrderUID, it.ChrtID, it.TrackNumber, it.Price,
-			it.Rid, it.Name, it.Sale, it.Size, it.TotalPrice, it.NmID, it.Brand, it.Status); err != nil {
+func (r *PostgresRepository) missingOrConflict(ctx context.Context, tx *sql.Tx, orderUID string) error {
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE order_uid = $1)`, orderUID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return service.ErrOrderNotFound
+	}
+	return service.ErrVersionConflict
+}
+
+var changeEvents = map[string]string{
+	models.ChangeCreate: models.EventOrderCreated,
+	models.ChangeUpdate: models.EventOrderUpdated,
+	models.ChangeDelete: models.EventOrderDeleted,
+}
+
+// recordChange appends the change to the order history, queues the matching
+// event in the outbox and notifies other instances, all in the transaction of
+// the change itself.
+// Deletes are recorded without a snapshot.
+func recordChange(ctx context.Context, tx *sql.Tx, order *models.Order, version int, operation string) error {
+	var snapshot any
+	if operation != models.ChangeDelete {
+		snap := *order
+		snap.Version = version
+		data, err := json.Marshal(&snap)
+		if err != nil {
 			return err
 		}
+		snapshot = string(data)
 	}
 
-	if err := tx.Commit(); err != nil {
+	src := models.ChangeSourceFrom(ctx)
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO order_history (order_uid, version, operation, snapshot, source, source_ref)
+VALUES ($1,$2,$3,$4,$5,$6)
+`, order.OrderUID, version, operation, snapshot, nullString(src.Kind), nullString(src.Ref))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO outbox (event_type, order_uid, version, payload)
+VALUES ($1,$2,$3,$4)
+`, changeEvents[operation], order.OrderUID, version, snapshot)
+	if err != nil {
+		return err
+	}
+
+	note, err := json.Marshal(orderChange{OrderUID: order.OrderUID, Version: version, Operation: operation})
+	if err != nil {
 		return err
 	}
+	_, err = tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, OrderChangesChannel, string(note))
+	return err
+}
+
+// claimOutboxSQL leases the oldest unleased events that are the first
+// unpublished event of their order. Whether an earlier event exists does not
+// depend on leases, which a concurrent claim may not have committed yet: an
+// event only becomes claimable once the one before it is deleted as published.
+const claimOutboxSQL = `
+UPDATE outbox SET locked_until = now() + make_interval(secs => $2)
+WHERE id IN (
+SELECT id FROM outbox o
+WHERE (o.locked_until IS NULL OR o.locked_until < now())
+AND NOT EXISTS (SELECT 1 FROM outbox p WHERE p.order_uid = o.order_uid AND p.id < o.id)
+ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED)
+RETURNING id, event_type, order_uid, version, payload, created_at`
+
+// ClaimOutbox leases up to limit of the oldest outbox events for the given
+// duration and returns them, at most one per order. The claim commits right
+// away, so no transaction stays open while the events are published. The
+// next event of an order is only claimed after the previous one is deleted,
+// so concurrent relays keep each order's events in sequence. Events whose
+// lease runs out unpublished are claimed again.
+func (r *PostgresRepository) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEvent, error) {
+	qctx, cancel := ctxWithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(qctx, claimOutboxSQL, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.OutboxEvent
+	for rows.Next() {
+		var ev models.OutboxEvent
+		var payload sql.NullString
+		if err := rows.Scan(&ev.ID, &ev.Type, &ev.OrderUID, &ev.Version, &payload, &ev.CreatedAt); err != nil {
+			return nil, err
+		}
+		if payload.Valid {
+			ev.Payload = json.RawMessage(payload.String)
+		}
+		events = append(events, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// RETURNING does not keep the order of the subquery
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, nil
+}
+
+// DeleteOutbox removes published events.
+func (r *PostgresRepository) DeleteOutbox(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	qctx, cancel := ctxWithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	strs := make([]string, len(ids))
+	for i, id := range ids {
+		strs[i] = strconv.FormatInt(id, 10)
+	}
+	_, err := r.db.ExecContext(qctx, `DELETE FROM outbox WHERE id = ANY($1::bigint[])`, "{"+strings.Join(strs, ",")+"}")
+	return err
+}
+
+// markProcessed stores the idempotency key of the change source, if any.
+// It runs first in the transaction, so a concurrent write with the same key
+// waits here and then fails with ErrAlreadyProcessed.
+func markProcessed(ctx context.Context, tx *sql.Tx, orderUID string) error {
+	src := models.ChangeSourceFrom(ctx)
+	if src.IdempotencyKey == "" {
+		return nil
+	}
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO processed_messages (message_key, order_uid, request_hash)
+VALUES ($1,$2,$3)
+ON CONFLICT (message_key) DO NOTHING
+`, src.IdempotencyKey, orderUID, nullString(src.RequestHash))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return service.ErrAlreadyProcessed
+	}
 	return nil
 }
 
-func (r *PostgresRepository) GetAllOrders(ctx context.Context) ([]*models.Order, error) {
-	qctx, cancel := ctxWithTimeout(ctx, 15*time.Second)
+// DeleteProcessedBefore deletes up to limit idempotency keys processed before
+// the given time and returns how many it deleted.
+func (r *PostgresRepository) DeleteProcessedBefore(ctx context.Context, before time.Time, limit int) (int, error) {
+	qctx, cancel := ctxWithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	query := `
+	res, err := r.db.ExecContext(qctx, `
+DELETE FROM processed_messages WHERE message_key IN (
+SELECT message_key FROM processed_messages WHERE processed_at < $1 LIMIT $2)`, before, limit)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *PostgresRepository) GetProcessedMessage(ctx context.Context, key string) (*models.ProcessedMessage, error) {
+	qctx, cancel := ctxWithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	pm := &models.ProcessedMessage{Key: key}
+	var hash sql.NullString
+	err := r.db.QueryRowContext(qctx, `
+SELECT order_uid, request_hash, processed_at FROM processed_messages WHERE message_key = $1`, key).
+		Scan(&pm.OrderUID, &hash, &pm.ProcessedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pm.RequestHash = hash.String
+	return pm, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// rawPayloadArg passes the payload as bytea, byte for byte. An empty payload
+// becomes NULL.
+func rawPayloadArg(raw []byte) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	return raw
+}
+
+func warningsArg(warnings []models.Violation) any {
+	if len(warnings) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(warnings)
+	if err != nil {
+		return nil
+	}
+	return string(b)
+}
+
+const itemColumns = 12
+
+// maxItemsPerInsert keeps one items insert under Postgres' limit of 65535
+// bind parameters.
+const maxItemsPerInsert = 65535 / itemColumns
+
+// insertItems writes the order's items with one multi-row insert per
+// maxItemsPerInsert items.
+func insertItems(ctx context.Context, tx *sql.Tx, order *models.Order) error {
+	for start := 0; start < len(order.Items); start += maxItemsPerInsert {
+		items := order.Items[start:min(start+maxItemsPerInsert, len(order.Items))]
+		args := make([]any, 0, len(items)*itemColumns)
+		for _, it := range items {
+			args = append(args, order.OrderUID, it.ChrtID, it.TrackNumber, it.Price,
+				it.Rid, it.Name, it.Sale, it.Size, it.TotalPrice, it.NmID, it.Brand, it.Status)
+		}
+		if _, err := tx.ExecContext(ctx, insertItemsSQL(len(items)), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertItemsSQL(rows int) string {
+	var sb strings.Builder
+	sb.WriteString(`INSERT INTO items (order_uid, chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status) VALUES `)
+	for row := 0; row < rows; row++ {
+		if row > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteByte('(')
+		for col := 1; col <= itemColumns; col++ {
+			if col > 1 {
+				sb.WriteByte(',')
+			}
+			sb.WriteByte('$')
+			sb.WriteString(strconv.Itoa(row*itemColumns + col))
+		}
+		sb.WriteByte(')')
+	}
+	return sb.String()
+}
+
+const orderJSONSelect = `
 SELECT json_build_object(
 	'order_uid', o.order_uid,
 	'track_number', o.track_number,
@@ -268,8 +738,10 @@ SELECT json_build_object(
 	'delivery_service', o.delivery_service,
 	'shardkey', o.shardkey,
 	'sm_id', o.sm_id,
-	'date_created', to_char(o.date_created AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'),
+	'date_created', to_char(o.date_created AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'),
 	'oof_shard', o.oof_shard,
+	'version', o.version,
+	'warnings', o.warnings,
 	'delivery', json_build_object(
 		'name', d.name,
 		'phone', d.phone,
@@ -293,11 +765,9 @@ SELECT json_build_object(
 	),
 	'items', COALESCE(it.items, '[]'::json)
 ) AS order_json
-FROM orders o
-LEFT JOIN delivery d ON d.order_uid = o.order_uid
-LEFT JOIN payment p ON p.order_uid = o.order_uid
-LEFT JOIN (
-	SELECT order_uid, json_agg(json_build_object(
+`
+
+const itemsJSONAgg = `json_agg(json_build_object(
 		'chrt_id', chrt_id,
 		'track_number', track_number,
 		'price', price,
@@ -309,7 +779,18 @@ LEFT JOIN (
 		'nm_id', nm_id,
 		'brand', brand,
 		'status', status
-	)) AS items
+	) ORDER BY id)`
+
+func (r *PostgresRepository) GetAllOrders(ctx context.Context) ([]*models.Order, error) {
+	qctx, cancel := ctxWithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	query := orderJSONSelect + `
+FROM orders o
+LEFT JOIN delivery d ON d.order_uid = o.order_uid
+LEFT JOIN payment p ON p.order_uid = o.order_uid
+LEFT JOIN (
+	SELECT order_uid, ` + itemsJSONAgg + ` AS items
 	FROM items
 	GROUP BY order_uid
 ) it ON it.order_uid = o.order_uid
@@ -321,6 +802,123 @@ ORDER BY o.date_created DESC;
 	}
 	defer rows.Close()
 
+	return scanOrdersJSON(rows)
+}
+
+func (r *PostgresRepository) ListOrders(ctx context.Context, filter models.OrderFilter) ([]*models.Order, error) {
+	qctx, cancel := ctxWithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var (
+		conds []string
+		args  []any
+	)
+	add := func(cond string, v any) {
+		args = append(args, v)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if filter.CustomerID != "" {
+		add("o.customer_id = $%d", filter.CustomerID)
+	}
+	if filter.TrackNumber != "" {
+		add("o.track_number = $%d", filter.TrackNumber)
+	}
+	if filter.Transaction != "" {
+		add("EXISTS (SELECT 1 FROM payment p WHERE p.order_uid = o.order_uid AND p.transaction = $%d)", filter.Transaction)
+	}
+	if filter.Rid != "" {
+		add("EXISTS (SELECT 1 FROM items i WHERE i.order_uid = o.order_uid AND i.rid = $%d)", filter.Rid)
+	}
+	if filter.DeliveryService != "" {
+		add("o.delivery_service = $%d", filter.DeliveryService)
+	}
+	if filter.Brand != "" {
+		add("EXISTS (SELECT 1 FROM items i WHERE i.order_uid = o.order_uid AND i.brand = $%d)", filter.Brand)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		add("o.date_created >= $%d", filter.CreatedFrom)
+	}
+	if !filter.CreatedTo.IsZero() {
+		add("o.date_created < $%d", filter.CreatedTo)
+	}
+	if filter.After != nil {
+		args = append(args, filter.After.DateCreated, filter.After.OrderUID)
+		conds = append(conds, fmt.Sprintf("(o.date_created, o.order_uid) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	args = append(args, filter.Limit)
+
+	query := `
+WITH page AS (
+	SELECT o.* FROM orders o
+	` + where + `
+	ORDER BY o.date_created DESC, o.order_uid DESC
+	LIMIT $` + strconv.Itoa(len(args)) + `
+)` + orderJSONSelect + `
+FROM page o
+LEFT JOIN delivery d ON d.order_uid = o.order_uid
+LEFT JOIN payment p ON p.order_uid = o.order_uid
+LEFT JOIN LATERAL (
+	SELECT ` + itemsJSONAgg + ` AS items
+	FROM items
+	WHERE items.order_uid = o.order_uid
+) it ON true
+ORDER BY o.date_created DESC, o.order_uid DESC;
+`
+	rows, err := r.db.QueryContext(qctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanOrdersJSON(rows)
+}
+
+// StreamOrders walks orders newest first in keyset-paginated batches of
+// batchSize, stopping after limit orders when limit is positive.
+func (r *PostgresRepository) StreamOrders(ctx context.Context, batchSize, limit int, fn func([]*models.Order) error) error {
+	return r.StreamFilteredOrders(ctx, models.OrderFilter{}, batchSize, limit, fn)
+}
+
+// StreamFilteredOrders is StreamOrders over the orders matching filter;
+// filter.Limit is ignored.
+func (r *PostgresRepository) StreamFilteredOrders(ctx context.Context, filter models.OrderFilter, batchSize, limit int, fn func([]*models.Order) error) error {
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	loaded := 0
+	for {
+		filter.Limit = batchSize
+		if limit > 0 && limit-loaded < batchSize {
+			filter.Limit = limit - loaded
+		}
+
+		orders, err := r.ListOrders(ctx, filter)
+		if err != nil {
+			return err
+		}
+		if len(orders) == 0 {
+			return nil
+		}
+		if err := fn(orders); err != nil {
+			return err
+		}
+
+		loaded += len(orders)
+		if len(orders) < filter.Limit || (limit > 0 && loaded >= limit) {
+			return nil
+		}
+		last := orders[len(orders)-1]
+		filter.After = &models.OrderCursor{DateCreated: last.DateCreated, OrderUID: last.OrderUID}
+	}
+}
+
+func scanOrdersJSON(rows *sql.Rows) ([]*models.Order, error) {
 	var orders []*models.Order
 	for rows.Next() {
 		var raw json.RawMessage
@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"wb-tech-1task/internal/models"
+)
+
+// SearchOrders finds orders by words from the customer name, phone, email,
+// city, track number, item names and brands. Every word may be a prefix;
+// misspelled words still match through trigram similarity. Results are
+// ordered by relevance, then newest first.
+func (r *PostgresRepository) SearchOrders(ctx context.Context, query string, limit int) ([]*models.Order, error) {
+	tsquery := prefixTSQuery(query)
+	if tsquery == "" {
+		return nil, nil
+	}
+
+	qctx, cancel := ctxWithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(qctx, `
+WITH q AS (
+	SELECT to_tsquery('simple', $1) AS tsq
+), page AS (
+	SELECT o.*, ts_rank(o.search_vector, q.tsq) + word_similarity($2, o.search_text) AS rank
+	FROM orders o, q
+	WHERE o.search_vector @@ q.tsq OR $2 <% o.search_text
+	ORDER BY rank DESC, o.date_created DESC
+	LIMIT $3
+)`+orderJSONSelect+`
+FROM page o
+LEFT JOIN delivery d ON d.order_uid = o.order_uid
+LEFT JOIN payment p ON p.order_uid = o.order_uid
+LEFT JOIN LATERAL (
+	SELECT `+itemsJSONAgg+` AS items
+	FROM items
+	WHERE items.order_uid = o.order_uid
+) it ON true
+ORDER BY o.rank DESC, o.date_created DESC;
+`, tsquery, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanOrdersJSON(rows)
+}
+
+func refreshSearch(ctx context.Context, tx *sql.Tx, orderUID string) error {
+	_, err := tx.ExecContext(ctx, `SELECT refresh_order_search($1)`, orderUID)
+	return err
+}
+
+// prefixTSQuery turns free text into a tsquery matching every word as a
+// prefix. Words are quoted, so tsquery operators typed by the user are
+// treated as text.
+func prefixTSQuery(query string) string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		word = strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(word)
+		terms = append(terms, "'"+word+"':*")
+	}
+	return strings.Join(terms, " & ")
+}
@@ -0,0 +1,13 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixTSQuery(t *testing.T) {
+	assert.Equal(t, "", prefixTSQuery("   "))
+	assert.Equal(t, "'ivan':* & 'moscow':*", prefixTSQuery(" ivan  moscow "))
+	assert.Equal(t, `'o''brien':* & 'a\\b':* & '!&|':*`, prefixTSQuery(`o'brien a\b !&|`))
+}
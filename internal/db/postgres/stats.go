@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"wb-tech-1task/internal/models"
+)
+
+// OrderVolume buckets orders by filter.Bucket in UTC and sums payment amounts
+// per currency.
+func (r *PostgresRepository) OrderVolume(ctx context.Context, filter models.StatsFilter) ([]models.OrderVolume, error) {
+	qctx, cancel := ctxWithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(qctx, `
+SELECT date_trunc($1, o.date_created AT TIME ZONE 'UTC') AS bucket, p.currency, count(*), COALESCE(sum(p.amount), 0)
+FROM orders o
+JOIN payment p ON p.order_uid = o.order_uid
+WHERE o.date_created >= $2 AND o.date_created < $3 AND ($4 = '' OR p.currency = $4)
+GROUP BY bucket, p.currency
+ORDER BY bucket, p.currency;
+`, filter.Bucket, filter.From, filter.To, filter.Currency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var volume []models.OrderVolume
+	for rows.Next() {
+		var v models.OrderVolume
+		if err := rows.Scan(&v.Bucket, &v.Currency, &v.Orders, &v.Amount); err != nil {
+			return nil, err
+		}
+		v.Bucket = v.Bucket.UTC()
+		volume = append(volume, v)
+	}
+	return volume, rows.Err()
+}
+
+func (r *PostgresRepository) TopBrands(ctx context.Context, filter models.StatsFilter) ([]models.Sales, error) {
+	return r.topSales(ctx, filter, `i.brand AS brand, 0 AS nm_id, '' AS name`, `i.brand`)
+}
+
+func (r *PostgresRepository) TopProducts(ctx context.Context, filter models.StatsFilter) ([]models.Sales, error) {
+	return r.topSales(ctx, filter, `'' AS brand, i.nm_id AS nm_id, min(i.name) AS name`, `i.nm_id`)
+}
+
+// topSales ranks items grouped by groupBy by the number sold. Revenue is
+// summed per payment currency, since amounts in different currencies can't be
+// added up.
+func (r *PostgresRepository) topSales(ctx context.Context, filter models.StatsFilter, columns, groupBy string) ([]models.Sales, error) {
+	qctx, cancel := ctxWithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(qctx, `
+WITH s AS (
+	SELECT `+columns+`, p.currency, count(*) AS sold, COALESCE(sum(i.total_price), 0) AS revenue
+	FROM items i
+	JOIN orders o ON o.order_uid = i.order_uid
+	JOIN payment p ON p.order_uid = i.order_uid
+	WHERE o.date_created >= $1 AND o.date_created < $2 AND ($3 = '' OR p.currency = $3)
+	GROUP BY `+groupBy+`, p.currency
+)
+SELECT brand, nm_id, min(name), sum(sold) AS sold, json_object_agg(currency, revenue)
+FROM s
+GROUP BY brand, nm_id
+ORDER BY sold DESC, brand, nm_id
+LIMIT $4;
+`, filter.From, filter.To, filter.Currency, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sales []models.Sales
+	for rows.Next() {
+		var (
+			s       models.Sales
+			revenue []byte
+		)
+		if err := rows.Scan(&s.Brand, &s.NmID, &s.Name, &s.Items, &revenue); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(revenue, &s.Revenue); err != nil {
+			return nil, err
+		}
+		sales = append(sales, s)
+	}
+	return sales, rows.Err()
+}
+
+// DeliveryShares returns every delivery service's share of the orders, largest
+// first. With filter.Currency only orders paid in that currency are counted.
+func (r *PostgresRepository) DeliveryShares(ctx context.Context, filter models.StatsFilter) ([]models.DeliveryShare, error) {
+	qctx, cancel := ctxWithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(qctx, `
+SELECT o.delivery_service, count(*) AS n, count(*)::float8 / sum(count(*)) OVER ()
+FROM orders o
+WHERE o.date_created >= $1 AND o.date_created < $2
+	AND ($3 = '' OR EXISTS (SELECT 1 FROM payment p WHERE p.order_uid = o.order_uid AND p.currency = $3))
+GROUP BY o.delivery_service
+ORDER BY n DESC, o.delivery_service;
+`, filter.From, filter.To, filter.Currency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []models.DeliveryShare
+	for rows.Next() {
+		var s models.DeliveryShare
+		if err := rows.Scan(&s.DeliveryService, &s.Orders, &s.Share); err != nil {
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+	return shares, rows.Err()
+}
@@ -0,0 +1,125 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	StatusUp       = "up"
+	StatusDown     = "down"
+	StatusDegraded = "degraded"
+)
+
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+type ComponentReport struct {
+	Status      string     `json:"status"`
+	Critical    bool       `json:"critical"`
+	LatencyMs   float64    `json:"latency_ms"`
+	Error       string     `json:"error,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+type Report struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentReport `json:"components"`
+}
+
+type component struct {
+	name        string
+	checker     Checker
+	critical    bool
+	lastError   string
+	lastErrorAt time.Time
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	components []*component
+	timeout    time.Duration
+}
+
+func NewRegistry(timeout time.Duration) *Registry {
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	return &Registry{timeout: timeout}
+}
+
+// Register adds a dependency check. A failing critical component marks the
+// whole report as down; a failing non-critical one only degrades it.
+func (r *Registry) Register(name string, checker Checker, critical bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.components = append(r.components, &component{name: name, checker: checker, critical: critical})
+}
+
+func (r *Registry) Check(ctx context.Context) Report {
+	r.mu.Lock()
+	components := make([]*component, len(r.components))
+	copy(components, r.components)
+	r.mu.Unlock()
+
+	type result struct {
+		comp    *component
+		err     error
+		latency time.Duration
+	}
+	results := make([]result, len(components))
+
+	var wg sync.WaitGroup
+	for i, comp := range components {
+		wg.Add(1)
+		go func(i int, comp *component) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, r.timeout)
+			defer cancel()
+			start := time.Now()
+			err := comp.checker.Check(cctx)
+			results[i] = result{comp: comp, err: err, latency: time.Since(start)}
+		}(i, comp)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusUp, Components: make(map[string]ComponentReport, len(results))}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, res := range results {
+		cr := ComponentReport{
+			Status:    StatusUp,
+			Critical:  res.comp.critical,
+			LatencyMs: float64(res.latency.Microseconds()) / 1000,
+		}
+		if res.err != nil {
+			cr.Status = StatusDown
+			cr.Error = res.err.Error()
+			res.comp.lastError = cr.Error
+			res.comp.lastErrorAt = time.Now()
+
+			if res.comp.critical {
+				report.Status = StatusDown
+			} else if report.Status == StatusUp {
+				report.Status = StatusDegraded
+			}
+		}
+		if res.comp.lastError != "" {
+			at := res.comp.lastErrorAt
+			cr.LastError = res.comp.lastError
+			cr.LastErrorAt = &at
+		}
+		report.Components[res.comp.name] = cr
+	}
+	return report
+}
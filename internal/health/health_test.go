@@ -0,0 +1,68 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_AllUp(t *testing.T) {
+	r := NewRegistry(time.Second)
+	r.Register("postgres", CheckerFunc(func(ctx context.Context) error { return nil }), true)
+	r.Register("cache", CheckerFunc(func(ctx context.Context) error { return nil }), true)
+
+	report := r.Check(context.Background())
+
+	assert.Equal(t, StatusUp, report.Status)
+	assert.Len(t, report.Components, 2)
+	assert.Equal(t, StatusUp, report.Components["postgres"].Status)
+}
+
+func TestRegistry_NonCriticalFailureDegrades(t *testing.T) {
+	r := NewRegistry(time.Second)
+	r.Register("postgres", CheckerFunc(func(ctx context.Context) error { return nil }), true)
+	r.Register("kafka", CheckerFunc(func(ctx context.Context) error { return errors.New("broker unreachable") }), false)
+
+	report := r.Check(context.Background())
+
+	assert.Equal(t, StatusDegraded, report.Status)
+	assert.Equal(t, StatusDown, report.Components["kafka"].Status)
+	assert.Equal(t, "broker unreachable", report.Components["kafka"].Error)
+}
+
+func TestRegistry_CriticalFailureAndLastError(t *testing.T) {
+	fail := true
+	r := NewRegistry(time.Second)
+	r.Register("postgres", CheckerFunc(func(ctx context.Context) error {
+		if fail {
+			return errors.New("connection refused")
+		}
+		return nil
+	}), true)
+
+	report := r.Check(context.Background())
+	assert.Equal(t, StatusDown, report.Status)
+
+	fail = false
+	report = r.Check(context.Background())
+	assert.Equal(t, StatusUp, report.Status)
+	assert.Empty(t, report.Components["postgres"].Error)
+	assert.Equal(t, "connection refused", report.Components["postgres"].LastError)
+	assert.NotNil(t, report.Components["postgres"].LastErrorAt)
+}
+
+func TestRegistry_CheckTimesOut(t *testing.T) {
+	r := NewRegistry(20 * time.Millisecond)
+	r.Register("slow", CheckerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}), true)
+
+	report := r.Check(context.Background())
+
+	assert.Equal(t, StatusDown, report.Status)
+	assert.Contains(t, report.Components["slow"].Error, "deadline exceeded")
+}
@@ -4,15 +4,24 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
+	"strconv"
+	"sync"
 	"time"
 
 	kafka "github.com/segmentio/kafka-go"
 
+	"wb-tech-1task/internal/metrics"
 	"wb-tech-1task/internal/models"
+	"wb-tech-1task/internal/service"
 )
 
+// commitTimeout bounds a single offset commit.
+const commitTimeout = 5 * time.Second
+
 type Reader interface {
 	FetchMessage(ctx context.Context) (kafka.Message, error)
 	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
@@ -26,18 +35,72 @@ type Writer interface {
 
 type OrderSaver interface {
 	SaveOrder(ctx context.Context, order *models.Order) error
+	UpdateOrder(ctx context.Context, order *models.Order, expectedVersion int) error
+}
+
+// BatchSaver is implemented by services that can create several orders in
+// one transaction; the consumer then saves queued creates together.
+type BatchSaver interface {
+	SaveOrders(ctx context.Context, orders []*models.Order) error
+}
+
+type ConsumerOptions struct {
+	Retry RetryPolicy
+	// Workers is the number of messages processed concurrently. Messages
+	// with the same key (or, without a key, from the same partition) always
+	// go to the same worker, so their relative order is preserved.
+	Workers int
+	// Completed offsets are committed every CommitInterval or as soon as
+	// CommitBatch messages have completed, whichever comes first.
+	CommitInterval time.Duration
+	CommitBatch    int
+	// BatchSize caps how many messages already queued for a worker are
+	// taken at once; consecutive creates among them are saved in one
+	// transaction. 1 disables batching.
+	BatchSize int
+}
+
+func DefaultConsumerOptions() ConsumerOptions {
+	return ConsumerOptions{
+		Retry:          DefaultRetryPolicy(),
+		Workers:        1,
+		CommitInterval: time.Second,
+		CommitBatch:    100,
+		BatchSize:      1,
+	}
 }
 
 type Consumer struct {
+	brokers          []string
 	reader           Reader
 	service          OrderSaver
 	deadLetterWriter Writer
+
+	retry        RetryPolicy
+	retryWriters []Writer
+	// level is 0 for the main topic and n for the topic of retry.Tiers[n-1].
+	level int
+
+	workers        int
+	commitInterval time.Duration
+	commitBatch    int
+	batchSize      int
+}
+
+func NewConsumer(brokers []string, topic, groupID string, svc OrderSaver, opts ConsumerOptions) *Consumer {
+	return newConsumer(brokers, topic, topic, groupID, svc, opts, 0)
 }
 
-func NewConsumer(brokers []string, topic, groupID string, svc OrderSaver) *Consumer {
+// NewRetryConsumer reads the retry topic of the given tier, holding every
+// message until the tier delay has passed since it was written.
+func NewRetryConsumer(brokers []string, topic, groupID string, svc OrderSaver, opts ConsumerOptions, tier int) *Consumer {
+	return newConsumer(brokers, topic, RetryTopic(topic, opts.Retry.Tiers[tier]), groupID, svc, opts, tier+1)
+}
+
+func newConsumer(brokers []string, baseTopic, readTopic, groupID string, svc OrderSaver, opts ConsumerOptions, level int) *Consumer {
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:  brokers,
-		Topic:    topic,
+		Topic:    readTopic,
 		GroupID:  groupID,
 		MinBytes: 10e3,
 		MaxBytes: 10e6,
@@ -46,74 +109,415 @@ func NewConsumer(brokers []string, topic, groupID string, svc OrderSaver) *Consu
 
 	deadLetterWriter := &kafka.Writer{
 		Addr:     kafka.TCP(brokers...),
-		Topic:    topic + "_dead_letter",
+		Topic:    baseTopic + "_dead_letter",
 		Balancer: &kafka.LeastBytes{},
 	}
 
+	retryWriters := make([]Writer, len(opts.Retry.Tiers))
+	for i, t := range opts.Retry.Tiers {
+		retryWriters[i] = &kafka.Writer{
+			Addr:     kafka.TCP(brokers...),
+			Topic:    RetryTopic(baseTopic, t),
+			Balancer: &kafka.Hash{},
+		}
+	}
+
 	return &Consumer{
+		brokers:          brokers,
 		reader:           reader,
 		service:          svc,
 		deadLetterWriter: deadLetterWriter,
+		retry:            opts.Retry,
+		retryWriters:     retryWriters,
+		level:            level,
+		workers:          opts.Workers,
+		commitInterval:   opts.CommitInterval,
+		commitBatch:      opts.CommitBatch,
+		batchSize:        opts.BatchSize,
 	}
 }
 
 func (c *Consumer) Run(ctx context.Context) error {
+	workers := max(c.workers, 1)
+	commitInterval := c.commitInterval
+	if commitInterval <= 0 {
+		commitInterval = time.Second
+	}
+
+	tracker := newOffsetTracker()
+	flushNow := make(chan struct{}, 1)
+
+	queues := make([]chan kafka.Message, workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan kafka.Message, 16)
+		wg.Add(1)
+		go func(queue <-chan kafka.Message) {
+			defer wg.Done()
+			for msg := range queue {
+				batch := takeQueued(queue, msg, c.batchSize)
+				for i, committable := range c.handleBatch(ctx, batch) {
+					// a message that was neither saved nor forwarded stays
+					// undone, so its partition is not committed past it
+					if !committable {
+						continue
+					}
+					if n := tracker.Done(batch[i]); c.commitBatch > 0 && n >= c.commitBatch {
+						select {
+						case flushNow <- struct{}{}:
+						default:
+						}
+					}
+				}
+			}
+		}(queues[i])
+	}
+
+	committerDone := make(chan struct{})
+	stopCommitter := make(chan struct{})
+	go func() {
+		defer close(committerDone)
+		ticker := time.NewTicker(commitInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-flushNow:
+			case <-stopCommitter:
+				return
+			}
+			// not ctx: a tick after cancellation must still commit, or the
+			// final commit below finds nothing left to commit
+			c.commit(tracker)
+		}
+	}()
+
+	c.fetchLoop(ctx, tracker, queues)
+
+	for _, q := range queues {
+		close(q)
+	}
+	wg.Wait()
+	close(stopCommitter)
+	<-committerDone
+
+	c.commit(tracker)
+	return nil
+}
+
+func (c *Consumer) fetchLoop(ctx context.Context, tracker *offsetTracker, queues []chan kafka.Message) {
 	for {
 		msg, err := c.reader.FetchMessage(ctx)
 		if err != nil {
 			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-				return nil
+				return
 			}
 			if err == io.EOF {
-				return nil
+				return
 			}
 			log.Printf("failed to fetch message: %v", err)
 			continue
 		}
 
-		if err := c.processMessage(ctx, msg); err != nil {
-			log.Printf("failed to process message: %v", err)
-			if derr := c.sendToDeadLetter(ctx, msg, err); derr != nil {
-				log.Printf("failed to send to dead letter queue: %v", derr)
+		metrics.KafkaLag.WithLabelValues(msg.Topic, strconv.Itoa(msg.Partition)).Set(float64(max(msg.HighWaterMark-msg.Offset-1, 0)))
+
+		tracker.Track(msg)
+		select {
+		case queues[workerFor(msg, len(queues))] <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// takeQueued returns first followed by the messages already waiting in
+// queue, up to limit in total. It never waits for more.
+func takeQueued(queue <-chan kafka.Message, first kafka.Message, limit int) []kafka.Message {
+	batch := []kafka.Message{first}
+	for len(batch) < limit {
+		select {
+		case msg, ok := <-queue:
+			if !ok {
+				return batch
 			}
+			batch = append(batch, msg)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// handleBatch saves every run of consecutive creates in msgs with a single
+// SaveOrders call. Other messages, and all messages of a run that failed as
+// a whole, go through handleMessage one by one, so retries, dead lettering
+// and duplicate detection work as without batching. It reports for each
+// message whether its offset may be committed.
+func (c *Consumer) handleBatch(ctx context.Context, msgs []kafka.Message) []bool {
+	committable := make([]bool, len(msgs))
+	saver, ok := c.service.(BatchSaver)
+	if !ok || c.level > 0 || len(msgs) == 1 {
+		for i, msg := range msgs {
+			committable[i] = c.handleMessage(ctx, msg)
+		}
+		return committable
+	}
+
+	var (
+		run     []int
+		orders  []*models.Order
+		sources = make(map[string]models.ChangeSource)
+	)
+	flush := func() {
+		if len(run) > 1 {
+			if err := saver.SaveOrders(models.WithOrderSources(ctx, sources), orders); err == nil {
+				for _, i := range run {
+					committable[i] = true
+				}
+				metrics.KafkaMessages.WithLabelValues("processed").Add(float64(len(run)))
+				run, orders, sources = nil, nil, make(map[string]models.ChangeSource)
+				return
+			}
+		}
+		for _, i := range run {
+			committable[i] = c.handleMessage(ctx, msgs[i])
+		}
+		run, orders, sources = nil, nil, make(map[string]models.ChangeSource)
+	}
+
+	for i, msg := range msgs {
+		op, _ := headerValue(msg, HeaderOperation)
+		var order *models.Order
+		if op == "" || op == OperationCreate {
+			order, _ = decodeOrder(msg.Value)
+		}
+		if order == nil {
+			flush()
+			committable[i] = c.handleMessage(ctx, msg)
 			continue
 		}
+		run = append(run, i)
+		orders = append(orders, order)
+		sources[order.OrderUID] = messageSource(msg)
+	}
+	flush()
+	return committable
+}
+
+// commit uses its own timeout rather than the run's context, which is
+// already cancelled when the last offsets are committed on shutdown. Offsets
+// that fail to commit are kept and retried by the next commit.
+func (c *Consumer) commit(tracker *offsetTracker) {
+	msgs := tracker.Committable()
+	if len(msgs) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), commitTimeout)
+	defer cancel()
+	if err := c.reader.CommitMessages(ctx, msgs...); err != nil {
+		log.Printf("failed to commit %d offsets: %v", len(msgs), err)
+		return
+	}
+	tracker.Committed(msgs)
+}
+
+func workerFor(msg kafka.Message, workers int) int {
+	if workers <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	if len(msg.Key) > 0 {
+		h.Write(msg.Key)
+	} else {
+		h.Write([]byte(msg.Topic))
+		h.Write([]byte(strconv.Itoa(msg.Partition)))
+	}
+	return int(h.Sum32() % uint32(workers))
+}
+
+// handleMessage processes msg with in-process retries and, if it still
+// fails, forwards it to the next retry tier or the dead letter topic. It
+// reports whether the offset may be committed.
+func (c *Consumer) handleMessage(ctx context.Context, msg kafka.Message) bool {
+	if c.level > 0 && c.level <= len(c.retry.Tiers) {
+		if !sleepCtx(ctx, time.Until(msg.Time.Add(c.retry.Tiers[c.level-1].Delay))) {
+			return false
+		}
+	}
+
+	attempts := 1
+	err := c.processMessage(ctx, msg)
+	backoff := c.retry.InitialBackoff
+	for err != nil && IsRetryable(err) && attempts < c.retry.MaxAttempts {
+		if !sleepCtx(ctx, backoff) {
+			return false
+		}
+		backoff = nextBackoff(backoff, c.retry)
+		attempts++
+		err = c.processMessage(ctx, msg)
+	}
+
+	if err == nil {
+		metrics.KafkaMessages.WithLabelValues("processed").Inc()
+		return true
+	}
+	if errors.Is(err, service.ErrAlreadyProcessed) {
+		// redelivered after a crash between saving and committing
+		metrics.KafkaMessages.WithLabelValues("duplicate").Inc()
+		return true
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+
+	log.Printf("failed to process message after %d attempts: %v", attempts, err)
+	metrics.KafkaProcessingErrors.Inc()
+	metrics.KafkaMessages.WithLabelValues("failed").Inc()
 
-		if err := c.reader.CommitMessages(ctx, msg); err != nil {
-			log.Printf("failed to commit message offset=%d: %v", msg.Offset, err)
+	msg.Headers = withHeader(withOrigin(msg), HeaderAttempts, strconv.Itoa(attemptsFromHeaders(msg)+attempts))
+
+	if next := c.level; IsRetryable(err) && next < len(c.retryWriters) {
+		if !c.forward(ctx, "retry topic", func() error { return c.sendToRetry(ctx, next, msg, err) }) {
+			return false
 		}
+		metrics.KafkaRetries.WithLabelValues(c.retry.Tiers[next].Name).Inc()
+		return true
+	}
+
+	if !c.forward(ctx, "dead letter queue", func() error { return c.sendToDeadLetter(ctx, msg, err) }) {
+		return false
+	}
+	metrics.KafkaDeadLetters.Inc()
+	return true
+}
+
+// forward calls send until it succeeds, backing off between attempts. It
+// only gives up when ctx is done, since the message is lost if its offset
+// is committed before it reached the retry or dead letter topic.
+func (c *Consumer) forward(ctx context.Context, dest string, send func() error) bool {
+	backoff := c.retry.InitialBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryPolicy().InitialBackoff
+	}
+	for {
+		err := send()
+		if err == nil {
+			return true
+		}
+		log.Printf("failed to send to %s: %v", dest, err)
+		if !sleepCtx(ctx, backoff) {
+			return false
+		}
+		backoff = nextBackoff(backoff, c.retry)
 	}
 }
 
 func (c *Consumer) processMessage(ctx context.Context, msg kafka.Message) error {
-	var order models.Order
-	if err := json.Unmarshal(msg.Value, &order); err != nil {
-		return err
+	return applyOrder(ctx, c.service, msg)
+}
+
+// applyOrder creates or updates the order carried by msg depending on its
+// operation header; messages without the header are creates.
+func applyOrder(ctx context.Context, svc OrderSaver, msg kafka.Message) error {
+	op, _ := headerValue(msg, HeaderOperation)
+	if op != "" && op != OperationCreate && op != OperationUpdate {
+		return permanent(errors.New("unknown operation: " + op))
+	}
+	version := 0
+	if v, ok := headerValue(msg, HeaderVersion); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return permanent(errors.New("invalid version header: " + v))
+		}
+		version = n
 	}
 
-	if err := order.Validate(); err != nil {
+	order, err := decodeOrder(msg.Value)
+	if err != nil {
 		return err
 	}
 
-	if err := c.service.SaveOrder(ctx, &order); err != nil {
-		return err
+	ctx = models.WithChangeSource(ctx, messageSource(msg))
+	if op == OperationUpdate {
+		return svc.UpdateOrder(ctx, order, version)
 	}
-	return nil
+	return svc.SaveOrder(ctx, order)
 }
 
-func (c *Consumer) sendToDeadLetter(ctx context.Context, msg kafka.Message, procErr error) error {
-	headers := append(msg.Headers, kafka.Header{
-		Key:   "error",
-		Value: []byte(procErr.Error()),
+// messageSource identifies msg by its coordinates. Its idempotency key comes
+// from the coordinates it was first consumed at, so the message is saved once
+// whether the main topic, a retry topic or a DLQ replay delivers it.
+func messageSource(msg kafka.Message) models.ChangeSource {
+	ref := messageRef(msg)
+	origin, ok := headerValue(msg, HeaderOrigin)
+	if !ok {
+		origin = ref
+	}
+	return models.ChangeSource{
+		Kind:           models.SourceKafka,
+		Ref:            ref,
+		IdempotencyKey: "kafka:" + origin,
+	}
+}
+
+func messageRef(msg kafka.Message) string {
+	return fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)
+}
+
+// withOrigin returns the headers of msg with HeaderOrigin set to its own
+// coordinates unless it already came from elsewhere.
+func withOrigin(msg kafka.Message) []kafka.Header {
+	if _, ok := headerValue(msg, HeaderOrigin); ok {
+		return msg.Headers
+	}
+	return withHeader(msg.Headers, HeaderOrigin, messageRef(msg))
+}
+
+func decodeOrder(value []byte) (*models.Order, error) {
+	var order models.Order
+	if err := json.Unmarshal(value, &order); err != nil {
+		return nil, permanent(err)
+	}
+
+	if err := order.Validate(); err != nil {
+		return nil, permanent(err)
+	}
+	order.RawPayload = value
+	return &order, nil
+}
+
+func (c *Consumer) sendToRetry(ctx context.Context, tier int, msg kafka.Message, procErr error) error {
+	return c.retryWriters[tier].WriteMessages(ctx, kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: withHeader(msg.Headers, HeaderError, procErr.Error()),
+		Time:    time.Now(),
 	})
+}
+
+func (c *Consumer) sendToDeadLetter(ctx context.Context, msg kafka.Message, procErr error) error {
 	return c.deadLetterWriter.WriteMessages(ctx, kafka.Message{
 		Key:     msg.Key,
 		Value:   msg.Value,
-		Headers: headers,
+		Headers: deadLetterHeaders(msg.Headers, procErr),
 		Time:    time.Now(),
 	})
 }
 
+func (c *Consumer) Ping(ctx context.Context) error {
+	lastErr := errors.New("no kafka brokers configured")
+	for _, broker := range c.brokers {
+		conn, err := kafka.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		_ = conn.Close()
+		return nil
+	}
+	return lastErr
+}
+
 func (c *Consumer) Close() error {
 	var firstErr error
 	if c.reader != nil {
@@ -126,5 +530,10 @@ func (c *Consumer) Close() error {
 			firstErr = err
 		}
 	}
+	for _, w := range c.retryWriters {
+		if err := w.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
 	return firstErr
 }
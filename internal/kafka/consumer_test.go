@@ -4,19 +4,23 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	kafka "github.com/segmentio/kafka-go"
 	"wb-tech-1task/internal/models"
+	"wb-tech-1task/internal/service"
 )
 
 type fakeReader struct {
 	msgs        []kafka.Message
 	fetchCalled int
 	committed   []kafka.Message
+	commitErrs  []error
 	closed      bool
 }
 
@@ -30,6 +34,16 @@ func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
 }
 
 func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(r.commitErrs) > 0 {
+		err := r.commitErrs[0]
+		r.commitErrs = r.commitErrs[1:]
+		if err != nil {
+			return err
+		}
+	}
 	r.committed = append(r.committed, msgs...)
 	return nil
 }
@@ -43,6 +57,7 @@ type fakeWriter struct {
 	written []kafka.Message
 	closed  bool
 	err     error
+	onWrite func()
 }
 
 func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
@@ -50,6 +65,9 @@ func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) e
 		return w.err
 	}
 	w.written = append(w.written, msgs...)
+	if w.onWrite != nil {
+		w.onWrite()
+	}
 	return nil
 }
 
@@ -58,9 +76,29 @@ func (w *fakeWriter) Close() error {
 	return nil
 }
 
+type flakyService struct {
+	failures int
+	calls    int
+	err      error
+}
+
+func (f *flakyService) SaveOrder(ctx context.Context, order *models.Order) error {
+	f.calls++
+	if f.calls <= f.failures {
+		return f.err
+	}
+	return nil
+}
+
+func (f *flakyService) UpdateOrder(ctx context.Context, order *models.Order, expectedVersion int) error {
+	return f.SaveOrder(ctx, order)
+}
+
 type dummyService struct {
-	saved *models.Order
-	err   error
+	saved   *models.Order
+	updated *models.Order
+	version int
+	err     error
 }
 
 func (d *dummyService) SaveOrder(ctx context.Context, order *models.Order) error {
@@ -71,6 +109,14 @@ func (d *dummyService) SaveOrder(ctx context.Context, order *models.Order) error
 	return nil
 }
 
+func (d *dummyService) UpdateOrder(ctx context.Context, order *models.Order, expectedVersion int) error {
+	if d.err != nil {
+		return d.err
+	}
+	d.updated, d.version = order, expectedVersion
+	return nil
+}
+
 func sampleOrderJSON() []byte {
 	order := models.Order{
 		OrderUID:          "test123",
@@ -188,6 +234,9 @@ func TestProcessMessage_Success(t *testing.T) {
 	if !hasNonZeroField(svc.saved) {
 		t.Fatalf("saved order appears to have all zero fields (bad sample JSON or model mismatch)")
 	}
+	if string(svc.saved.RawPayload) != string(msg.Value) {
+		t.Fatalf("expected raw payload to be the message value")
+	}
 }
 
 func TestProcessMessage_InvalidJSON(t *testing.T) {
@@ -218,6 +267,34 @@ func TestProcessMessage_SaveOrderError(t *testing.T) {
 	}
 }
 
+func TestProcessMessage_UpdateOperation(t *testing.T) {
+	svc := &dummyService{}
+	c := &Consumer{service: svc}
+
+	msg := kafka.Message{
+		Value: sampleOrderJSON(),
+		Headers: []kafka.Header{
+			{Key: HeaderOperation, Value: []byte(OperationUpdate)},
+			{Key: HeaderVersion, Value: []byte("4")},
+		},
+	}
+
+	if err := c.processMessage(context.Background(), msg); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if svc.saved != nil || svc.updated == nil {
+		t.Fatalf("expected UpdateOrder to be called instead of SaveOrder")
+	}
+	if svc.version != 4 {
+		t.Fatalf("expected version 4 from header, got %d", svc.version)
+	}
+
+	msg.Headers = []kafka.Header{{Key: HeaderOperation, Value: []byte("upsert")}}
+	if err := c.processMessage(context.Background(), msg); err == nil || IsRetryable(err) {
+		t.Fatalf("expected permanent error for unknown operation, got %v", err)
+	}
+}
+
 func TestSendToDeadLetter_WritesMessageWithErrorHeader(t *testing.T) {
 	fw := &fakeWriter{}
 	c := &Consumer{deadLetterWriter: fw}
@@ -252,6 +329,54 @@ func TestSendToDeadLetter_WritesMessageWithErrorHeader(t *testing.T) {
 	}
 }
 
+func TestSendToDeadLetter_AddsValidationHeaders(t *testing.T) {
+	fw := &fakeWriter{}
+	c := &Consumer{deadLetterWriter: fw}
+
+	_, procErr := decodeOrder([]byte(`{"order_uid":"x"}`))
+	if procErr == nil {
+		t.Fatalf("expected validation error")
+	}
+	if err := c.sendToDeadLetter(context.Background(), kafka.Message{Value: []byte("v")}, procErr); err != nil {
+		t.Fatalf("sendToDeadLetter returned error: %v", err)
+	}
+
+	w := fw.written[0]
+	if v, _ := headerValue(w, HeaderErrorType); v != ErrorTypeValidation {
+		t.Fatalf("expected error type %q, got %q", ErrorTypeValidation, v)
+	}
+	dl := toDeadLetter(w)
+	if len(dl.Violations) == 0 {
+		t.Fatalf("expected violations in dead letter, got none")
+	}
+	if dl.Violations[0].Path != "track_number" || dl.Violations[0].Rule != models.RuleRequired {
+		t.Fatalf("unexpected first violation: %+v", dl.Violations[0])
+	}
+}
+
+func TestCommit_FailedOffsetsAreRetried(t *testing.T) {
+	fr := &fakeReader{commitErrs: []error{errors.New("broker down")}}
+	c := &Consumer{reader: fr}
+
+	tr := newOffsetTracker()
+	msg := kafka.Message{Topic: "orders", Partition: 0, Offset: 3}
+	tr.Track(msg)
+	tr.Done(msg)
+
+	c.commit(tr)
+	if len(fr.committed) != 0 {
+		t.Fatalf("expected the first commit to fail, got %v", fr.committed)
+	}
+	c.commit(tr)
+	if len(fr.committed) != 1 || fr.committed[0].Offset != 3 {
+		t.Fatalf("expected offset 3 committed on retry, got %v", fr.committed)
+	}
+	c.commit(tr)
+	if len(fr.committed) != 1 {
+		t.Fatalf("expected committed offsets not to be committed again, got %v", fr.committed)
+	}
+}
+
 func TestRun_ProcessAndCommitThenEOFStops(t *testing.T) {
 	msg := kafka.Message{Key: []byte("k"), Value: sampleOrderJSON(), Offset: 123}
 
@@ -308,3 +433,351 @@ func TestClose_ClosesReaderAndWriter(t *testing.T) {
 		t.Fatalf("writer not closed")
 	}
 }
+
+func testRetryPolicy(tiers int) RetryPolicy {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	for i := 0; i < tiers; i++ {
+		policy.Tiers = append(policy.Tiers, RetryTier{Name: fmt.Sprintf("t%d", i), Delay: time.Millisecond})
+	}
+	return policy
+}
+
+func TestHandleMessage_TransientErrorRetriedInProcess(t *testing.T) {
+	svc := &flakyService{failures: 2, err: errors.New("connection reset")}
+	dlq := &fakeWriter{}
+	c := &Consumer{service: svc, deadLetterWriter: dlq, retry: testRetryPolicy(0)}
+
+	if !c.handleMessage(context.Background(), kafka.Message{Value: sampleOrderJSON()}) {
+		t.Fatalf("expected message to be committable")
+	}
+	if svc.calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", svc.calls)
+	}
+	if len(dlq.written) != 0 {
+		t.Fatalf("expected nothing dead-lettered, got %d", len(dlq.written))
+	}
+}
+
+func TestHandleMessage_DuplicateIsCommittedWithoutDeadLetter(t *testing.T) {
+	svc := &dummyService{err: fmt.Errorf("save: %w", service.ErrAlreadyProcessed)}
+	dlq := &fakeWriter{}
+	c := &Consumer{service: svc, deadLetterWriter: dlq, retry: RetryPolicy{MaxAttempts: 3}}
+
+	if !c.handleMessage(context.Background(), kafka.Message{Value: sampleOrderJSON()}) {
+		t.Fatalf("expected duplicate message to be committable")
+	}
+	if len(dlq.written) != 0 {
+		t.Fatalf("expected no dead letters for a duplicate, got %d", len(dlq.written))
+	}
+}
+
+func TestHandleMessage_PermanentErrorGoesStraightToDeadLetter(t *testing.T) {
+	svc := &flakyService{}
+	dlq := &fakeWriter{}
+	retry := &fakeWriter{}
+	c := &Consumer{service: svc, deadLetterWriter: dlq, retry: testRetryPolicy(1), retryWriters: []Writer{retry}}
+
+	if !c.handleMessage(context.Background(), kafka.Message{Value: []byte("{invalid-json")}) {
+		t.Fatalf("expected message to be committable")
+	}
+	if len(retry.written) != 0 {
+		t.Fatalf("permanent error must not be sent to retry topic")
+	}
+	if len(dlq.written) != 1 {
+		t.Fatalf("expected 1 dead-lettered message, got %d", len(dlq.written))
+	}
+	if v, _ := headerValue(dlq.written[0], HeaderAttempts); v != "1" {
+		t.Fatalf("expected attempts header 1, got %q", v)
+	}
+}
+
+func TestHandleMessage_ExhaustedRetriesForwardToNextTier(t *testing.T) {
+	svc := &flakyService{failures: 100, err: errors.New("db down")}
+	dlq := &fakeWriter{}
+	tier0 := &fakeWriter{}
+	tier1 := &fakeWriter{}
+	c := &Consumer{service: svc, deadLetterWriter: dlq, retry: testRetryPolicy(2), retryWriters: []Writer{tier0, tier1}}
+
+	if !c.handleMessage(context.Background(), kafka.Message{Value: sampleOrderJSON()}) {
+		t.Fatalf("expected message to be committable")
+	}
+	if len(tier0.written) != 1 || len(dlq.written) != 0 {
+		t.Fatalf("expected message in first retry tier, got tier0=%d dlq=%d", len(tier0.written), len(dlq.written))
+	}
+	fwd := tier0.written[0]
+	if v, _ := headerValue(fwd, HeaderAttempts); v != "3" {
+		t.Fatalf("expected attempts header 3, got %q", v)
+	}
+
+	c.level = 1
+	if !c.handleMessage(context.Background(), fwd) {
+		t.Fatalf("expected message to be committable")
+	}
+	if len(tier1.written) != 1 {
+		t.Fatalf("expected message in second retry tier, got %d", len(tier1.written))
+	}
+	if v, _ := headerValue(tier1.written[0], HeaderAttempts); v != "6" {
+		t.Fatalf("expected attempts header 6, got %q", v)
+	}
+
+	c.level = 2
+	if !c.handleMessage(context.Background(), tier1.written[0]) {
+		t.Fatalf("expected message to be committable")
+	}
+	if len(dlq.written) != 1 {
+		t.Fatalf("expected message dead-lettered after last tier, got %d", len(dlq.written))
+	}
+	errHeaders := 0
+	for _, h := range dlq.written[0].Headers {
+		if h.Key == HeaderError {
+			errHeaders++
+		}
+	}
+	if errHeaders != 1 {
+		t.Fatalf("expected a single error header, got %d", errHeaders)
+	}
+}
+
+func TestHandleMessage_ForwardedMessageKeepsIdempotencyKey(t *testing.T) {
+	svc := &flakyService{failures: 100, err: errors.New("db down")}
+	tier0 := &fakeWriter{}
+	dlq := &fakeWriter{}
+	c := &Consumer{service: svc, deadLetterWriter: dlq, retry: testRetryPolicy(1), retryWriters: []Writer{tier0}}
+
+	orig := kafka.Message{Topic: "orders", Partition: 2, Offset: 40, Value: sampleOrderJSON()}
+	c.handleMessage(context.Background(), orig)
+	if len(tier0.written) != 1 {
+		t.Fatalf("expected message in the retry tier, got %d", len(tier0.written))
+	}
+
+	retried := tier0.written[0]
+	retried.Topic, retried.Partition, retried.Offset = "orders_retry_t0", 0, 7
+	c.level = 1
+	c.handleMessage(context.Background(), retried)
+	if len(dlq.written) != 1 {
+		t.Fatalf("expected message dead-lettered, got %d", len(dlq.written))
+	}
+
+	dead := dlq.written[0]
+	dead.Topic, dead.Partition, dead.Offset = "orders_dead_letter", 1, 3
+	want := messageSource(orig).IdempotencyKey
+	for _, msg := range []kafka.Message{retried, dead} {
+		src := messageSource(msg)
+		if src.IdempotencyKey != want {
+			t.Fatalf("expected idempotency key %q for %s, got %q", want, src.Ref, src.IdempotencyKey)
+		}
+	}
+	if want != "kafka:orders/2/40" {
+		t.Fatalf("unexpected original key %q", want)
+	}
+}
+
+type orderingService struct {
+	mu    sync.Mutex
+	seen  map[string][]string
+	delay map[string]time.Duration
+}
+
+func (s *orderingService) SaveOrder(ctx context.Context, order *models.Order) error {
+	time.Sleep(s.delay[order.OrderUID])
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen[order.CustomerID] = append(s.seen[order.CustomerID], order.OrderUID)
+	return nil
+}
+
+func (s *orderingService) UpdateOrder(ctx context.Context, order *models.Order, expectedVersion int) error {
+	return s.SaveOrder(ctx, order)
+}
+
+func orderMessage(t *testing.T, key, uid string, offset int64) kafka.Message {
+	t.Helper()
+	var order models.Order
+	if err := json.Unmarshal(sampleOrderJSON(), &order); err != nil {
+		t.Fatalf("unmarshal sample: %v", err)
+	}
+	order.OrderUID = uid
+	order.CustomerID = key
+	data, _ := json.Marshal(order)
+	return kafka.Message{Topic: "orders", Partition: 0, Offset: offset, Key: []byte(key), Value: data}
+}
+
+func TestRun_ConcurrentWorkersPreserveKeyOrderAndCommitContiguously(t *testing.T) {
+	msgs := []kafka.Message{
+		orderMessage(t, "a", "a1", 0),
+		orderMessage(t, "b", "b1", 1),
+		orderMessage(t, "a", "a2", 2),
+		orderMessage(t, "c", "c1", 3),
+		orderMessage(t, "a", "a3", 4),
+	}
+	svc := &orderingService{
+		seen:  map[string][]string{},
+		delay: map[string]time.Duration{"a1": 30 * time.Millisecond, "b1": 10 * time.Millisecond},
+	}
+	fr := &fakeReader{msgs: msgs}
+	c := &Consumer{
+		reader:           fr,
+		service:          svc,
+		deadLetterWriter: &fakeWriter{},
+		workers:          4,
+		commitInterval:   5 * time.Millisecond,
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.Run(context.Background()) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run returned error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Run did not finish in time")
+	}
+
+	if got := svc.seen["a"]; !reflect.DeepEqual(got, []string{"a1", "a2", "a3"}) {
+		t.Fatalf("key order not preserved: %v", got)
+	}
+	if len(fr.committed) == 0 {
+		t.Fatalf("expected offsets to be committed")
+	}
+	var prev int64 = -1
+	for _, m := range fr.committed {
+		if m.Offset <= prev {
+			t.Fatalf("commits must move forward, got %d after %d", m.Offset, prev)
+		}
+		prev = m.Offset
+	}
+	if last := fr.committed[len(fr.committed)-1].Offset; last != 4 {
+		t.Fatalf("expected final committed offset 4, got %d", last)
+	}
+}
+
+type batchService struct {
+	orderingService
+	batches  [][]string
+	sources  []string
+	batchErr error
+}
+
+func (s *batchService) SaveOrders(ctx context.Context, orders []*models.Order) error {
+	var uids []string
+	for _, o := range orders {
+		uids = append(uids, o.OrderUID)
+		s.sources = append(s.sources, models.OrderSourceFrom(ctx, o.OrderUID).IdempotencyKey)
+	}
+	s.batches = append(s.batches, uids)
+	return s.batchErr
+}
+
+func TestHandleBatch_SavesConsecutiveCreatesTogether(t *testing.T) {
+	update := orderMessage(t, "a", "u1", 2)
+	update.Headers = []kafka.Header{{Key: HeaderOperation, Value: []byte(OperationUpdate)}}
+	msgs := []kafka.Message{
+		orderMessage(t, "a", "a1", 0),
+		orderMessage(t, "a", "a2", 1),
+		update,
+		orderMessage(t, "a", "a3", 3),
+	}
+	svc := &batchService{orderingService: orderingService{seen: map[string][]string{}}}
+	c := &Consumer{service: svc, deadLetterWriter: &fakeWriter{}, retry: RetryPolicy{MaxAttempts: 1}}
+
+	committable := c.handleBatch(context.Background(), msgs)
+
+	if !reflect.DeepEqual(committable, []bool{true, true, true, true}) {
+		t.Fatalf("unexpected committable: %v", committable)
+	}
+	if !reflect.DeepEqual(svc.batches, [][]string{{"a1", "a2"}}) {
+		t.Fatalf("unexpected batches: %v", svc.batches)
+	}
+	if !reflect.DeepEqual(svc.sources, []string{"kafka:orders/0/0", "kafka:orders/0/1"}) {
+		t.Fatalf("unexpected sources: %v", svc.sources)
+	}
+	// the update and the lone trailing create are handled one by one
+	if got := svc.seen["a"]; !reflect.DeepEqual(got, []string{"u1", "a3"}) {
+		t.Fatalf("unexpected single saves: %v", got)
+	}
+}
+
+func TestHandleBatch_FailedBatchFallsBackToSingleMessages(t *testing.T) {
+	msgs := []kafka.Message{
+		orderMessage(t, "a", "a1", 0),
+		orderMessage(t, "a", "a2", 1),
+	}
+	svc := &batchService{
+		orderingService: orderingService{seen: map[string][]string{}},
+		batchErr:        service.ErrOrderExists,
+	}
+	c := &Consumer{service: svc, deadLetterWriter: &fakeWriter{}, retry: RetryPolicy{MaxAttempts: 1}}
+
+	c.handleBatch(context.Background(), msgs)
+
+	if got := svc.seen["a"]; !reflect.DeepEqual(got, []string{"a1", "a2"}) {
+		t.Fatalf("expected both messages to be saved singly, got %v", got)
+	}
+}
+
+func TestTakeQueued_DoesNotWait(t *testing.T) {
+	queue := make(chan kafka.Message, 4)
+	queue <- kafka.Message{Offset: 1}
+	queue <- kafka.Message{Offset: 2}
+
+	batch := takeQueued(queue, kafka.Message{Offset: 0}, 2)
+	if len(batch) != 2 || batch[1].Offset != 1 {
+		t.Fatalf("unexpected batch: %v", batch)
+	}
+	batch = takeQueued(queue, kafka.Message{Offset: 9}, 10)
+	if len(batch) != 2 || batch[1].Offset != 2 {
+		t.Fatalf("unexpected batch: %v", batch)
+	}
+}
+
+type failingWriter struct {
+	attempts chan struct{}
+}
+
+func (w *failingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	select {
+	case w.attempts <- struct{}{}:
+	default:
+	}
+	return errors.New("broker unavailable")
+}
+
+func (w *failingWriter) Close() error { return nil }
+
+func TestRun_FailedDeadLetterWriteIsNotCommitted(t *testing.T) {
+	msg := kafka.Message{Value: []byte("{invalid-json"), Offset: 7}
+	fr := &fakeReader{msgs: []kafka.Message{msg}}
+	dlq := &failingWriter{attempts: make(chan struct{}, 1)}
+	c := &Consumer{
+		reader:           fr,
+		deadLetterWriter: dlq,
+		service:          &dummyService{},
+		retry:            testRetryPolicy(0),
+		commitInterval:   time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+
+	// the write keeps being retried instead of giving up on the message
+	for i := 0; i < 3; i++ {
+		select {
+		case <-dlq.attempts:
+		case <-time.After(time.Second):
+			t.Fatalf("expected the dead letter write to be retried")
+		}
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Run did not stop after cancellation")
+	}
+	if len(fr.committed) != 0 {
+		t.Fatalf("expected no offsets committed for an unforwarded message, got %v", fr.committed)
+	}
+}
@@ -0,0 +1,422 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+
+	"wb-tech-1task/internal/models"
+)
+
+const HeaderReplayedFrom = "dlq-replayed-from"
+
+const (
+	ReplayPublish = "publish"
+	ReplaySave    = "save"
+)
+
+const (
+	ReplayStatusWouldReplay = "would_replay"
+	ReplayStatusReplayed    = "replayed"
+	ReplayStatusFailed      = "failed"
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter message not found")
+
+// DeadLetterSource reads the dead letter topic. Read returns
+// ErrDeadLetterNotFound when there is no message at the offset.
+type DeadLetterSource interface {
+	Scan(ctx context.Context, fn func(msg kafka.Message) error) error
+	Read(ctx context.Context, partition int, offset int64) (kafka.Message, error)
+}
+
+type DeadLetter struct {
+	Partition  int                `json:"partition"`
+	Offset     int64              `json:"offset"`
+	Key        string             `json:"key,omitempty"`
+	Value      string             `json:"value"`
+	Error      string             `json:"error,omitempty"`
+	Violations []models.Violation `json:"violations,omitempty"`
+	Attempts   int                `json:"attempts,omitempty"`
+	Headers    map[string]string  `json:"headers,omitempty"`
+	Time       time.Time          `json:"time"`
+}
+
+type DeadLetterFilter struct {
+	ErrorContains string
+	Key           string
+	Partition     *int
+	Offset        *int64
+	Limit         int
+}
+
+type ReplayItem struct {
+	Partition int    `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Key       string `json:"key,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type ReplayResult struct {
+	Mode     string       `json:"mode"`
+	DryRun   bool         `json:"dry_run"`
+	Matched  int          `json:"matched"`
+	Replayed int          `json:"replayed"`
+	Failed   int          `json:"failed"`
+	Items    []ReplayItem `json:"items"`
+}
+
+// DeadLetterReplayer reads the dead letter topic and feeds messages back
+// either to the main topic or directly through the order service. Kafka
+// cannot delete records, so replayed messages stay in the dead letter topic.
+type DeadLetterReplayer struct {
+	source    DeadLetterSource
+	publisher Writer
+	saver     OrderSaver
+}
+
+func NewDeadLetterReplayer(brokers []string, topic string, saver OrderSaver) *DeadLetterReplayer {
+	return NewDeadLetterReplayerFrom(
+		&partitionScanner{brokers: brokers, topic: topic + "_dead_letter"},
+		&kafka.Writer{
+			Addr:     kafka.TCP(brokers...),
+			Topic:    topic,
+			Balancer: &kafka.Hash{},
+		},
+		saver,
+	)
+}
+
+func NewDeadLetterReplayerFrom(source DeadLetterSource, publisher Writer, saver OrderSaver) *DeadLetterReplayer {
+	return &DeadLetterReplayer{source: source, publisher: publisher, saver: saver}
+}
+
+func (r *DeadLetterReplayer) List(ctx context.Context, filter DeadLetterFilter) ([]DeadLetter, error) {
+	var out []DeadLetter
+	err := r.scan(ctx, filter, func(msg kafka.Message) error {
+		out = append(out, toDeadLetter(msg))
+		return nil
+	})
+	return out, err
+}
+
+func (r *DeadLetterReplayer) Get(ctx context.Context, partition int, offset int64) (*DeadLetter, error) {
+	msg, err := r.source.Read(ctx, partition, offset)
+	if err != nil {
+		return nil, err
+	}
+	dl := toDeadLetter(msg)
+	return &dl, nil
+}
+
+func (r *DeadLetterReplayer) Replay(ctx context.Context, filter DeadLetterFilter, mode string, dryRun bool) (*ReplayResult, error) {
+	switch mode {
+	case ReplayPublish:
+	case ReplaySave:
+		if r.saver == nil && !dryRun {
+			return nil, errors.New("save mode requires an order service")
+		}
+	default:
+		return nil, fmt.Errorf("unknown replay mode %q", mode)
+	}
+
+	result := &ReplayResult{Mode: mode, DryRun: dryRun, Items: []ReplayItem{}}
+	err := r.scan(ctx, filter, func(msg kafka.Message) error {
+		dl := toDeadLetter(msg)
+		item := ReplayItem{Partition: dl.Partition, Offset: dl.Offset, Key: dl.Key, Error: dl.Error}
+		result.Matched++
+
+		if dryRun {
+			item.Status = ReplayStatusWouldReplay
+			result.Items = append(result.Items, item)
+			return nil
+		}
+
+		var rerr error
+		if mode == ReplayPublish {
+			rerr = r.publish(ctx, msg)
+		} else {
+			rerr = r.save(ctx, msg)
+		}
+		if rerr != nil {
+			item.Status = ReplayStatusFailed
+			item.Reason = rerr.Error()
+			result.Failed++
+		} else {
+			item.Status = ReplayStatusReplayed
+			result.Replayed++
+		}
+		result.Items = append(result.Items, item)
+		return ctx.Err()
+	})
+	return result, err
+}
+
+func (r *DeadLetterReplayer) Close() error {
+	if r.publisher != nil {
+		return r.publisher.Close()
+	}
+	return nil
+}
+
+var errStopScan = errors.New("stop scan")
+
+func (r *DeadLetterReplayer) scan(ctx context.Context, filter DeadLetterFilter, fn func(msg kafka.Message) error) error {
+	matched := 0
+	err := r.source.Scan(ctx, func(msg kafka.Message) error {
+		if !filter.matches(msg) {
+			return nil
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+		matched++
+		if filter.Limit > 0 && matched >= filter.Limit {
+			return errStopScan
+		}
+		return nil
+	})
+	if errors.Is(err, errStopScan) {
+		return nil
+	}
+	return err
+}
+
+func (r *DeadLetterReplayer) publish(ctx context.Context, msg kafka.Message) error {
+	// a dead letter written without an origin is first seen here, so its
+	// replays share one idempotency key
+	headers := make([]kafka.Header, 0, len(msg.Headers)+2)
+	for _, h := range withOrigin(msg) {
+		switch h.Key {
+		case HeaderError, HeaderAttempts, HeaderErrorType, HeaderViolations:
+		default:
+			headers = append(headers, h)
+		}
+	}
+	headers = append(headers, kafka.Header{
+		Key:   HeaderReplayedFrom,
+		Value: []byte(strconv.Itoa(msg.Partition) + ":" + strconv.FormatInt(msg.Offset, 10)),
+	})
+	return r.publisher.WriteMessages(ctx, kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+		Time:    time.Now(),
+	})
+}
+
+func (r *DeadLetterReplayer) save(ctx context.Context, msg kafka.Message) error {
+	return applyOrder(ctx, r.saver, msg)
+}
+
+func (f DeadLetterFilter) matches(msg kafka.Message) bool {
+	if f.Partition != nil && msg.Partition != *f.Partition {
+		return false
+	}
+	if f.Offset != nil && msg.Offset != *f.Offset {
+		return false
+	}
+	if f.Key != "" && string(msg.Key) != f.Key {
+		return false
+	}
+	if f.ErrorContains != "" {
+		v, _ := headerValue(msg, HeaderError)
+		if !strings.Contains(strings.ToLower(v), strings.ToLower(f.ErrorContains)) {
+			return false
+		}
+	}
+	return true
+}
+
+func toDeadLetter(msg kafka.Message) DeadLetter {
+	dl := DeadLetter{
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       string(msg.Key),
+		Value:     string(msg.Value),
+		Attempts:  attemptsFromHeaders(msg),
+		Time:      msg.Time,
+	}
+	dl.Error, _ = headerValue(msg, HeaderError)
+	if v, ok := headerValue(msg, HeaderViolations); ok {
+		_ = json.Unmarshal([]byte(v), &dl.Violations)
+	}
+	if len(msg.Headers) > 0 {
+		dl.Headers = make(map[string]string, len(msg.Headers))
+		for _, h := range msg.Headers {
+			dl.Headers[h.Key] = string(h.Value)
+		}
+	}
+	return dl
+}
+
+// deadLetterReadTimeout bounds the wait for the next message of a partition.
+// Offsets below the high watermark may never arrive when compaction removed
+// the records at the end of the partition; the reader then still reaches the
+// high watermark, which ends the partition.
+const deadLetterReadTimeout = 3 * time.Second
+
+// partitionScanner reads every partition of a topic from the first offset to
+// the high watermark without a consumer group, so browsing the dead letter
+// topic never moves anyone's committed offsets.
+type partitionScanner struct {
+	brokers []string
+	topic   string
+}
+
+func (s *partitionScanner) Scan(ctx context.Context, fn func(msg kafka.Message) error) error {
+	var partitions []kafka.Partition
+	err := s.eachBroker(ctx, func(broker string) error {
+		conn, err := kafka.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		partitions, err = conn.ReadPartitions(s.topic)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range partitions {
+		if err := s.scanPartition(ctx, p.ID, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *partitionScanner) Read(ctx context.Context, partition int, offset int64) (kafka.Message, error) {
+	first, last, err := s.offsets(ctx, partition)
+	if errors.Is(err, kafka.UnknownTopicOrPartition) {
+		return kafka.Message{}, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	if offset < first || offset >= last {
+		return kafka.Message{}, ErrDeadLetterNotFound
+	}
+
+	reader, err := s.readerAt(partition, offset)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	defer reader.Close()
+
+	msg, ok, err := readBefore(ctx, reader, last)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	// a compacted offset makes the reader skip ahead to the next record
+	if !ok || msg.Offset != offset {
+		return kafka.Message{}, ErrDeadLetterNotFound
+	}
+	return msg, nil
+}
+
+func (s *partitionScanner) scanPartition(ctx context.Context, partition int, fn func(msg kafka.Message) error) error {
+	first, last, err := s.offsets(ctx, partition)
+	if err != nil {
+		return err
+	}
+	if first >= last {
+		return nil
+	}
+
+	reader, err := s.readerAt(partition, first)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for {
+		msg, ok, err := readBefore(ctx, reader, last)
+		if err != nil || !ok {
+			return err
+		}
+		if msg.Offset >= last {
+			return nil
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+		lagZero := msg.HighWaterMark > 0 && msg.Offset >= msg.HighWaterMark-1
+		if msg.Offset >= last-1 || lagZero {
+			return nil
+		}
+	}
+}
+
+// offsets returns the first offset and the high watermark of a partition.
+func (s *partitionScanner) offsets(ctx context.Context, partition int) (first, last int64, err error) {
+	err = s.eachBroker(ctx, func(broker string) error {
+		leader, err := kafka.DialLeader(ctx, "tcp", broker, s.topic, partition)
+		if err != nil {
+			return err
+		}
+		defer leader.Close()
+		first, last, err = leader.ReadOffsets()
+		return err
+	})
+	return first, last, err
+}
+
+// eachBroker calls fn with one broker after another until it succeeds, the
+// way kafka.Reader falls back to the next broker, and returns the last error.
+func (s *partitionScanner) eachBroker(ctx context.Context, fn func(broker string) error) error {
+	err := errors.New("no kafka brokers configured")
+	for _, broker := range s.brokers {
+		if err = fn(broker); err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *partitionScanner) readerAt(partition int, offset int64) (*kafka.Reader, error) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   s.brokers,
+		Topic:     s.topic,
+		Partition: partition,
+		MinBytes:  1,
+		MaxBytes:  10e6,
+		MaxWait:   500 * time.Millisecond,
+	})
+	if err := reader.SetOffset(offset); err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+// readBefore reads the next message, reporting false when none arrives
+// within deadLetterReadTimeout although the reader has reached last, the
+// high watermark. A timeout before that is an error, so a slow broker never
+// passes for the end of the partition.
+func readBefore(ctx context.Context, reader *kafka.Reader, last int64) (kafka.Message, bool, error) {
+	readCtx, cancel := context.WithTimeout(ctx, deadLetterReadTimeout)
+	defer cancel()
+
+	msg, err := reader.ReadMessage(readCtx)
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			if offset := reader.Offset(); offset < last {
+				return kafka.Message{}, false, fmt.Errorf("no message from offset %d of partition %d within %s, %d before its end",
+					offset, reader.Config().Partition, deadLetterReadTimeout, last-offset)
+			}
+			return kafka.Message{}, false, nil
+		}
+		return kafka.Message{}, false, err
+	}
+	return msg, true, nil
+}
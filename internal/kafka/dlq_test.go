@@ -0,0 +1,168 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+type fakeSource struct {
+	msgs []kafka.Message
+}
+
+func (s *fakeSource) Scan(ctx context.Context, fn func(msg kafka.Message) error) error {
+	for _, m := range s.msgs {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeSource) Read(ctx context.Context, partition int, offset int64) (kafka.Message, error) {
+	for _, m := range s.msgs {
+		if m.Partition == partition && m.Offset == offset {
+			return m, nil
+		}
+	}
+	return kafka.Message{}, ErrDeadLetterNotFound
+}
+
+func deadLetterSource() *fakeSource {
+	return &fakeSource{msgs: []kafka.Message{
+		{
+			Partition: 0, Offset: 0, Key: []byte("k1"), Value: sampleOrderJSON(),
+			Headers: []kafka.Header{{Key: HeaderError, Value: []byte("dial tcp: connection refused")}, {Key: HeaderAttempts, Value: []byte("9")}},
+		},
+		{
+			Partition: 0, Offset: 1, Key: []byte("k2"), Value: []byte("{invalid-json"),
+			Headers: []kafka.Header{{Key: HeaderError, Value: []byte("invalid character 'i'")}},
+		},
+		{
+			Partition: 1, Offset: 0, Key: []byte("k3"), Value: sampleOrderJSON(),
+			Headers: []kafka.Header{{Key: HeaderError, Value: []byte("Connection reset by peer")}},
+		},
+	}}
+}
+
+func TestDeadLetterReplayer_ListFiltersByError(t *testing.T) {
+	r := NewDeadLetterReplayerFrom(deadLetterSource(), &fakeWriter{}, nil)
+
+	list, err := r.List(context.Background(), DeadLetterFilter{ErrorContains: "connection"})
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("expected 2 matches, got %d", len(list))
+	}
+	if list[0].Attempts != 9 || list[0].Key != "k1" {
+		t.Fatalf("unexpected first message %+v", list[0])
+	}
+}
+
+func TestDeadLetterReplayer_Get(t *testing.T) {
+	r := NewDeadLetterReplayerFrom(deadLetterSource(), &fakeWriter{}, nil)
+
+	dl, err := r.Get(context.Background(), 1, 0)
+	if err != nil {
+		t.Fatalf("Get returned error: %v", err)
+	}
+	if dl.Key != "k3" {
+		t.Fatalf("expected k3, got %q", dl.Key)
+	}
+
+	if _, err := r.Get(context.Background(), 5, 5); err != ErrDeadLetterNotFound {
+		t.Fatalf("expected ErrDeadLetterNotFound, got %v", err)
+	}
+}
+
+func TestDeadLetterReplayer_DryRunWritesNothing(t *testing.T) {
+	w := &fakeWriter{}
+	r := NewDeadLetterReplayerFrom(deadLetterSource(), w, nil)
+
+	result, err := r.Replay(context.Background(), DeadLetterFilter{}, ReplayPublish, true)
+	if err != nil {
+		t.Fatalf("Replay returned error: %v", err)
+	}
+	if result.Matched != 3 || result.Replayed != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if len(w.written) != 0 {
+		t.Fatalf("dry run must not publish, got %d messages", len(w.written))
+	}
+	if result.Items[0].Status != ReplayStatusWouldReplay {
+		t.Fatalf("unexpected item status %q", result.Items[0].Status)
+	}
+}
+
+func TestDeadLetterReplayer_PublishStripsRetryHeaders(t *testing.T) {
+	w := &fakeWriter{}
+	r := NewDeadLetterReplayerFrom(deadLetterSource(), w, nil)
+
+	result, err := r.Replay(context.Background(), DeadLetterFilter{Key: "k1"}, ReplayPublish, false)
+	if err != nil {
+		t.Fatalf("Replay returned error: %v", err)
+	}
+	if result.Replayed != 1 || len(w.written) != 1 {
+		t.Fatalf("expected one replayed message, got result=%+v written=%d", result, len(w.written))
+	}
+	msg := w.written[0]
+	if _, ok := headerValue(msg, HeaderError); ok {
+		t.Fatalf("error header must be stripped on replay")
+	}
+	if _, ok := headerValue(msg, HeaderAttempts); ok {
+		t.Fatalf("attempts header must be stripped on replay")
+	}
+	if v, _ := headerValue(msg, HeaderReplayedFrom); v != "0:0" {
+		t.Fatalf("expected replayed-from header 0:0, got %q", v)
+	}
+	if v, _ := headerValue(msg, HeaderOrigin); v != "/0/0" {
+		t.Fatalf("expected the dead letter to become the origin, got %q", v)
+	}
+}
+
+func TestDeadLetterReplayer_SaveReportsPerMessageResult(t *testing.T) {
+	svc := &dummyService{}
+	r := NewDeadLetterReplayerFrom(deadLetterSource(), &fakeWriter{}, svc)
+
+	result, err := r.Replay(context.Background(), DeadLetterFilter{Partition: ptr(0)}, ReplaySave, false)
+	if err != nil {
+		t.Fatalf("Replay returned error: %v", err)
+	}
+	if result.Replayed != 1 || result.Failed != 1 {
+		t.Fatalf("expected 1 replayed and 1 failed, got %+v", result)
+	}
+	if result.Items[1].Status != ReplayStatusFailed || result.Items[1].Reason == "" {
+		t.Fatalf("expected failed item with reason, got %+v", result.Items[1])
+	}
+	if svc.saved == nil {
+		t.Fatalf("expected order to be saved")
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestPartitionScanner_EachBrokerFallsBack(t *testing.T) {
+	s := &partitionScanner{brokers: []string{"down:9092", "up:9092"}}
+
+	var tried []string
+	err := s.eachBroker(context.Background(), func(broker string) error {
+		tried = append(tried, broker)
+		if broker == "down:9092" {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	if err != nil || len(tried) != 2 {
+		t.Fatalf("expected the second broker to be used, tried %v, err %v", tried, err)
+	}
+
+	s.brokers = nil
+	if err := s.eachBroker(context.Background(), func(string) error { return nil }); err == nil {
+		t.Fatalf("expected an error without brokers")
+	}
+}
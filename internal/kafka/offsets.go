@@ -0,0 +1,102 @@
+package kafka
+
+import (
+	"sync"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// offsetTracker remembers fetched offsets per partition and only exposes
+// for commit the highest offset below which every message has completed,
+// so a slow message never lets a commit skip past it.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[topicPartition]*partitionOffsets
+	completed  int
+}
+
+type topicPartition struct {
+	topic     string
+	partition int
+}
+
+type partitionOffsets struct {
+	pending     []kafka.Message
+	done        map[int64]bool
+	committable *kafka.Message
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: make(map[topicPartition]*partitionOffsets)}
+}
+
+func partitionKey(msg kafka.Message) topicPartition {
+	return topicPartition{topic: msg.Topic, partition: msg.Partition}
+}
+
+// Track must be called in fetch order, before the message is handed to a worker.
+func (t *offsetTracker) Track(msg kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := partitionKey(msg)
+	p, ok := t.partitions[key]
+	if !ok {
+		p = &partitionOffsets{done: make(map[int64]bool)}
+		t.partitions[key] = p
+	}
+	p.pending = append(p.pending, kafka.Message{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset})
+}
+
+// Done marks msg completed and returns how many completions are waiting to
+// be committed.
+func (t *offsetTracker) Done(msg kafka.Message) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[partitionKey(msg)]
+	if !ok {
+		return t.completed
+	}
+	p.done[msg.Offset] = true
+	t.completed++
+
+	for len(p.pending) > 0 && p.done[p.pending[0].Offset] {
+		head := p.pending[0]
+		delete(p.done, head.Offset)
+		p.pending = p.pending[1:]
+		p.committable = &head
+	}
+	return t.completed
+}
+
+// Committable returns, per partition, the last contiguous completed message.
+// The offsets stay committable until Committed confirms them, so a failed
+// commit is retried by the next one.
+func (t *offsetTracker) Committable() []kafka.Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var out []kafka.Message
+	for _, p := range t.partitions {
+		if p.committable != nil {
+			out = append(out, *p.committable)
+		}
+	}
+	t.completed = 0
+	return out
+}
+
+// Committed forgets the committable offsets up to msgs, which were
+// committed successfully. Offsets that became committable meanwhile stay.
+func (t *offsetTracker) Committed(msgs []kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, msg := range msgs {
+		p, ok := t.partitions[partitionKey(msg)]
+		if ok && p.committable != nil && p.committable.Offset <= msg.Offset {
+			p.committable = nil
+		}
+	}
+}
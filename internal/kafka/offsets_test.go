@@ -0,0 +1,73 @@
+package kafka
+
+import (
+	"testing"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+func TestOffsetTracker_CommitsOnlyContiguousOffsets(t *testing.T) {
+	tr := newOffsetTracker()
+	msgs := []kafka.Message{
+		{Topic: "orders", Partition: 0, Offset: 10},
+		{Topic: "orders", Partition: 0, Offset: 11},
+		{Topic: "orders", Partition: 0, Offset: 12},
+	}
+	for _, m := range msgs {
+		tr.Track(m)
+	}
+
+	tr.Done(msgs[1])
+	tr.Done(msgs[2])
+	if got := tr.Committable(); len(got) != 0 {
+		t.Fatalf("expected nothing committable while offset 10 is in flight, got %v", got)
+	}
+
+	tr.Done(msgs[0])
+	got := tr.Committable()
+	if len(got) != 1 || got[0].Offset != 12 {
+		t.Fatalf("expected offset 12 committable, got %v", got)
+	}
+	if again := tr.Committable(); len(again) != 1 || again[0].Offset != 12 {
+		t.Fatalf("offsets must stay committable until committed, got %v", again)
+	}
+
+	tr.Committed(got)
+	if again := tr.Committable(); len(again) != 0 {
+		t.Fatalf("committed offsets must be forgotten, got %v", again)
+	}
+}
+
+func TestOffsetTracker_CommittedKeepsNewerOffsets(t *testing.T) {
+	tr := newOffsetTracker()
+	m1 := kafka.Message{Topic: "orders", Partition: 0, Offset: 1}
+	m2 := kafka.Message{Topic: "orders", Partition: 0, Offset: 2}
+	tr.Track(m1)
+	tr.Track(m2)
+
+	tr.Done(m1)
+	inFlight := tr.Committable()
+	tr.Done(m2)
+	tr.Committed(inFlight)
+
+	got := tr.Committable()
+	if len(got) != 1 || got[0].Offset != 2 {
+		t.Fatalf("expected offset 2 still committable, got %v", got)
+	}
+}
+
+func TestOffsetTracker_PartitionsAreIndependent(t *testing.T) {
+	tr := newOffsetTracker()
+	p0 := kafka.Message{Topic: "orders", Partition: 0, Offset: 5}
+	p1 := kafka.Message{Topic: "orders", Partition: 1, Offset: 7}
+	tr.Track(p0)
+	tr.Track(p1)
+
+	if n := tr.Done(p1); n != 1 {
+		t.Fatalf("expected 1 pending completion, got %d", n)
+	}
+	got := tr.Committable()
+	if len(got) != 1 || got[0].Partition != 1 || got[0].Offset != 7 {
+		t.Fatalf("expected partition 1 offset 7 committable, got %v", got)
+	}
+}
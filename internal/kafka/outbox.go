@@ -0,0 +1,178 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+
+	"wb-tech-1task/internal/metrics"
+	"wb-tech-1task/internal/models"
+)
+
+const (
+	HeaderEventType = "event-type"
+	HeaderEventID   = "event-id"
+)
+
+// OutboxStore leases outbox events to one relay at a time and deletes them
+// once published.
+type OutboxStore interface {
+	ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEvent, error)
+	DeleteOutbox(ctx context.Context, ids []int64) error
+}
+
+type OutboxOptions struct {
+	PollInterval time.Duration
+	BatchSize    int
+	// Lease is how long claimed events are reserved for publishing; events
+	// not deleted by then, e.g. after a failed publish, are claimed again.
+	Lease time.Duration
+}
+
+func DefaultOutboxOptions() OutboxOptions {
+	return OutboxOptions{
+		PollInterval: 500 * time.Millisecond,
+		BatchSize:    100,
+		Lease:        30 * time.Second,
+	}
+}
+
+const outboxDeleteTimeout = 5 * time.Second
+
+// OutboxRelay publishes order events queued in the outbox table. Events are
+// keyed by order_uid, so the events of one order keep their order within a
+// partition. Consumers may see an event twice and should dedupe by event-id.
+type OutboxRelay struct {
+	store     OutboxStore
+	publisher Writer
+	opts      OutboxOptions
+	logger    *zap.Logger
+}
+
+type orderEvent struct {
+	ID         int64           `json:"id"`
+	Type       string          `json:"type"`
+	OrderUID   string          `json:"order_uid"`
+	Version    int             `json:"version"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Order      json.RawMessage `json:"order,omitempty"`
+}
+
+func NewOutboxRelay(brokers []string, topic string, store OutboxStore, opts OutboxOptions, logger *zap.Logger) *OutboxRelay {
+	return NewOutboxRelayFrom(store, &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}, opts, logger)
+}
+
+func NewOutboxRelayFrom(store OutboxStore, publisher Writer, opts OutboxOptions, logger *zap.Logger) *OutboxRelay {
+	defaults := DefaultOutboxOptions()
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaults.PollInterval
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaults.BatchSize
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = defaults.Lease
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &OutboxRelay{store: store, publisher: publisher, opts: opts, logger: logger}
+}
+
+// Run polls the outbox until ctx is done, draining it batch by batch.
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := r.RelayOnce(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					r.logger.Error("outbox relay failed", zap.Error(err))
+					metrics.OutboxErrors.Inc()
+				}
+				break
+			}
+			if n < r.opts.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce claims a single batch, publishes it and deletes the published
+// events, returning how many it relayed. Publishing is bounded by the lease,
+// after which another relay may claim the same events.
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	events, err := r.store.ClaimOutbox(ctx, r.opts.BatchSize, r.opts.Lease)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	pctx, cancel := context.WithTimeout(ctx, r.opts.Lease)
+	err = r.publish(pctx, events)
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+	metrics.OutboxPublished.Add(float64(len(events)))
+
+	ids := make([]int64, len(events))
+	for i, ev := range events {
+		ids[i] = ev.ID
+	}
+	// the events are out already, so delete them even if ctx is done
+	dctx, cancel := context.WithTimeout(context.Background(), outboxDeleteTimeout)
+	defer cancel()
+	if err := r.store.DeleteOutbox(dctx, ids); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+func (r *OutboxRelay) publish(ctx context.Context, events []models.OutboxEvent) error {
+	msgs := make([]kafka.Message, 0, len(events))
+	for _, ev := range events {
+		value, err := json.Marshal(orderEvent{
+			ID:         ev.ID,
+			Type:       ev.Type,
+			OrderUID:   ev.OrderUID,
+			Version:    ev.Version,
+			OccurredAt: ev.CreatedAt,
+			Order:      ev.Payload,
+		})
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, kafka.Message{
+			Key:   []byte(ev.OrderUID),
+			Value: value,
+			Headers: []kafka.Header{
+				{Key: HeaderEventType, Value: []byte(ev.Type)},
+				{Key: HeaderEventID, Value: []byte(strconv.FormatInt(ev.ID, 10))},
+			},
+			Time: time.Now(),
+		})
+	}
+	return r.publisher.WriteMessages(ctx, msgs...)
+}
+
+func (r *OutboxRelay) Close() error {
+	return r.publisher.Close()
+}
@@ -0,0 +1,115 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"wb-tech-1task/internal/models"
+)
+
+type fakeOutbox struct {
+	events  []models.OutboxEvent
+	claimed map[int64]bool
+	lease   time.Duration
+}
+
+func (s *fakeOutbox) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEvent, error) {
+	if s.claimed == nil {
+		s.claimed = make(map[int64]bool)
+	}
+	s.lease = lease
+	var out []models.OutboxEvent
+	for _, ev := range s.events {
+		if len(out) < limit && !s.claimed[ev.ID] {
+			s.claimed[ev.ID] = true
+			out = append(out, ev)
+		}
+	}
+	return out, nil
+}
+
+func (s *fakeOutbox) DeleteOutbox(ctx context.Context, ids []int64) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	for _, id := range ids {
+		for i, ev := range s.events {
+			if ev.ID == id {
+				s.events = append(s.events[:i], s.events[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func TestOutboxRelay_PublishesEventsKeyedByOrder(t *testing.T) {
+	store := &fakeOutbox{events: []models.OutboxEvent{
+		{ID: 1, Type: models.EventOrderCreated, OrderUID: "o-1", Version: 1, Payload: json.RawMessage(`{"order_uid":"o-1"}`)},
+		{ID: 2, Type: models.EventOrderDeleted, OrderUID: "o-1", Version: 1},
+		{ID: 3, Type: models.EventOrderUpdated, OrderUID: "o-2", Version: 2, Payload: json.RawMessage(`{"order_uid":"o-2"}`)},
+	}}
+	w := &fakeWriter{}
+	relay := NewOutboxRelayFrom(store, w, OutboxOptions{BatchSize: 2}, nil)
+
+	if n, err := relay.RelayOnce(context.Background()); err != nil || n != 2 {
+		t.Fatalf("expected first batch of 2, got %d, %v", n, err)
+	}
+	if n, err := relay.RelayOnce(context.Background()); err != nil || n != 1 {
+		t.Fatalf("expected second batch of 1, got %d, %v", n, err)
+	}
+
+	if len(w.written) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(w.written))
+	}
+	msg := w.written[0]
+	if string(msg.Key) != "o-1" {
+		t.Fatalf("expected key o-1, got %q", msg.Key)
+	}
+	if v, _ := headerValue(msg, HeaderEventType); v != models.EventOrderCreated {
+		t.Fatalf("expected event type header, got %q", v)
+	}
+	if v, _ := headerValue(msg, HeaderEventID); v != "1" {
+		t.Fatalf("expected event id header 1, got %q", v)
+	}
+
+	var ev orderEvent
+	if err := json.Unmarshal(w.written[1].Value, &ev); err != nil {
+		t.Fatalf("event is not json: %v", err)
+	}
+	if ev.Type != models.EventOrderDeleted || ev.Order != nil {
+		t.Fatalf("unexpected delete event %+v", ev)
+	}
+}
+
+func TestOutboxRelay_KeepsEventsWhenPublishFails(t *testing.T) {
+	store := &fakeOutbox{events: []models.OutboxEvent{{ID: 1, Type: models.EventOrderCreated, OrderUID: "o-1"}}}
+	relay := NewOutboxRelayFrom(store, &fakeWriter{err: errors.New("broker down")}, OutboxOptions{}, nil)
+
+	if _, err := relay.RelayOnce(context.Background()); err == nil {
+		t.Fatalf("expected publish error")
+	}
+	if len(store.events) != 1 {
+		t.Fatalf("expected event to stay in the outbox")
+	}
+	if store.lease != DefaultOutboxOptions().Lease {
+		t.Fatalf("expected default lease, got %v", store.lease)
+	}
+}
+
+func TestOutboxRelay_DeletesPublishedEventsAfterCancel(t *testing.T) {
+	store := &fakeOutbox{events: []models.OutboxEvent{{ID: 1, Type: models.EventOrderCreated, OrderUID: "o-1"}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &fakeWriter{onWrite: cancel}
+	relay := NewOutboxRelayFrom(store, w, OutboxOptions{}, nil)
+
+	if n, err := relay.RelayOnce(ctx); err != nil || n != 1 {
+		t.Fatalf("expected 1 event relayed, got %d, %v", n, err)
+	}
+	if len(store.events) != 0 {
+		t.Fatalf("expected published event to be deleted, %d left", len(store.events))
+	}
+}
@@ -0,0 +1,192 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	kafka "github.com/segmentio/kafka-go"
+
+	"wb-tech-1task/internal/models"
+	"wb-tech-1task/internal/service"
+)
+
+const (
+	HeaderError     = "error"
+	HeaderAttempts  = "retry-attempts"
+	HeaderOperation = "operation"
+	HeaderVersion   = "order-version"
+	// HeaderOrigin holds the "topic/partition/offset" a message was first
+	// consumed at; retry topics and the dead letter queue keep it.
+	HeaderOrigin = "origin"
+
+	// HeaderErrorType and HeaderViolations are set on dead letters that
+	// failed validation; the latter holds the violations as a JSON array.
+	HeaderErrorType  = "error-type"
+	HeaderViolations = "validation-violations"
+)
+
+const ErrorTypeValidation = "validation"
+
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+)
+
+type RetryTier struct {
+	Name  string
+	Delay time.Duration
+}
+
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Tiers          []RetryTier
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+	}
+}
+
+// ParseRetryTiers turns tier names such as "5s" or "1m" into tiers whose
+// retry topic is "<topic>_retry_<name>".
+func ParseRetryTiers(names []string) ([]RetryTier, error) {
+	tiers := make([]RetryTier, 0, len(names))
+	for _, name := range names {
+		d, err := time.ParseDuration(name)
+		if err != nil || d <= 0 {
+			return nil, errors.New("invalid retry tier: " + name)
+		}
+		tiers = append(tiers, RetryTier{Name: name, Delay: d})
+	}
+	return tiers, nil
+}
+
+func RetryTopic(topic string, tier RetryTier) string {
+	return topic + "_retry_" + tier.Name
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsRetryable reports whether a processing failure may succeed on a later
+// attempt. Decode and validation failures and data/constraint errors from
+// Postgres never will; everything else (connection drops, timeouts,
+// serialization failures) is treated as transient.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var pe *permanentError
+	if errors.As(err, &pe) {
+		return false
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return false
+	}
+	if errors.Is(err, service.ErrOrderExists) || errors.Is(err, service.ErrOrderNotFound) || errors.Is(err, service.ErrInvalidOrder) ||
+		errors.Is(err, service.ErrVersionConflict) || errors.Is(err, service.ErrAlreadyProcessed) ||
+		errors.Is(err, context.Canceled) {
+		return false
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "22", "23", "42":
+			return false
+		}
+	}
+	return true
+}
+
+func nextBackoff(cur time.Duration, policy RetryPolicy) time.Duration {
+	next := cur * 2
+	if policy.MaxBackoff > 0 && next > policy.MaxBackoff {
+		next = policy.MaxBackoff
+	}
+	return next
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func headerValue(msg kafka.Message, key string) (string, bool) {
+	for i := len(msg.Headers) - 1; i >= 0; i-- {
+		if msg.Headers[i].Key == key {
+			return string(msg.Headers[i].Value), true
+		}
+	}
+	return "", false
+}
+
+func attemptsFromHeaders(msg kafka.Message) int {
+	v, ok := headerValue(msg, HeaderAttempts)
+	if !ok {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// withHeader returns a copy of headers with key set to value, replacing an
+// existing header of the same key instead of stacking duplicates.
+func withHeader(headers []kafka.Header, key, value string) []kafka.Header {
+	out := make([]kafka.Header, 0, len(headers)+1)
+	for _, h := range headers {
+		if h.Key != key {
+			out = append(out, h)
+		}
+	}
+	return append(out, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+// deadLetterHeaders adds the failure of a dead-lettered message to its
+// headers, with the violations listed separately for validation failures.
+func deadLetterHeaders(headers []kafka.Header, procErr error) []kafka.Header {
+	headers = withHeader(headers, HeaderError, procErr.Error())
+	var verr *models.ValidationError
+	if !errors.As(procErr, &verr) {
+		return headers
+	}
+	violations, err := json.Marshal(verr.Violations)
+	if err != nil {
+		return headers
+	}
+	headers = withHeader(headers, HeaderErrorType, ErrorTypeValidation)
+	return withHeader(headers, HeaderViolations, string(violations))
+}
@@ -0,0 +1,77 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"wb-tech-1task/internal/service"
+)
+
+func TestIsRetryable(t *testing.T) {
+	var syntaxErr *json.SyntaxError
+	decodeErr := json.Unmarshal([]byte("{bad"), &struct{}{})
+	if !errors.As(decodeErr, &syntaxErr) {
+		t.Fatalf("expected json syntax error, got %T", decodeErr)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection error", errors.New("dial tcp: connection refused"), true},
+		{"serialization failure", &pq.Error{Code: "40001"}, true},
+		{"validation", permanent(errors.New("order_uid is required")), false},
+		{"json decode", decodeErr, false},
+		{"unique violation", &pq.Error{Code: "23505"}, false},
+		{"value too long", fmt.Errorf("save: %w", &pq.Error{Code: "22001"}), false},
+		{"order exists", service.ErrOrderExists, false},
+		{"nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Fatalf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryTiers(t *testing.T) {
+	tiers, err := ParseRetryTiers([]string{"5s", "1m"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tiers) != 2 || tiers[1].Delay != time.Minute {
+		t.Fatalf("unexpected tiers %+v", tiers)
+	}
+	if got := RetryTopic("orders", tiers[0]); got != "orders_retry_5s" {
+		t.Fatalf("unexpected retry topic %q", got)
+	}
+
+	if _, err := ParseRetryTiers([]string{"soon"}); err == nil {
+		t.Fatalf("expected error for invalid tier")
+	}
+}
+
+func TestNextBackoff_CappedAtMax(t *testing.T) {
+	policy := RetryPolicy{MaxBackoff: 300 * time.Millisecond}
+	if got := nextBackoff(200*time.Millisecond, policy); got != 300*time.Millisecond {
+		t.Fatalf("expected backoff capped at 300ms, got %v", got)
+	}
+}
+
+func TestSleepCtx_ReturnsFalseOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if sleepCtx(ctx, time.Second) {
+		t.Fatalf("expected sleepCtx to stop on cancelled context")
+	}
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"wb-tech-1task/internal/cache"
+)
+
+type cacheCollector struct {
+	cache *cache.Cache
+
+	hits      *prometheus.Desc
+	misses    *prometheus.Desc
+	evictions *prometheus.Desc
+	entries   *prometheus.Desc
+	bytes     *prometheus.Desc
+}
+
+// NewCacheCollector exposes cache counters read from cache.Stats at scrape time.
+func NewCacheCollector(c *cache.Cache) prometheus.Collector {
+	fq := func(name string) string {
+		return prometheus.BuildFQName(namespace, "cache", name)
+	}
+	return &cacheCollector{
+		cache:     c,
+		hits:      prometheus.NewDesc(fq("hits_total"), "Cache lookups that found a live entry.", nil, nil),
+		misses:    prometheus.NewDesc(fq("misses_total"), "Cache lookups that found nothing or an expired entry.", nil, nil),
+		evictions: prometheus.NewDesc(fq("evictions_total"), "Entries evicted to stay within cache limits.", nil, nil),
+		entries:   prometheus.NewDesc(fq("entries"), "Orders currently held in the cache.", nil, nil),
+		bytes:     prometheus.NewDesc(fq("bytes"), "Estimated memory used by cached orders.", nil, nil),
+	}
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.entries
+	ch <- c.bytes
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.cache.Stats()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(s.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(s.Misses))
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(s.Evictions))
+	ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(s.Entries))
+	ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(s.Bytes))
+}
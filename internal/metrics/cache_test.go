@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"wb-tech-1task/internal/cache"
+	"wb-tech-1task/internal/models"
+)
+
+func TestCacheCollector(t *testing.T) {
+	c := cache.New(10 * time.Minute)
+	defer c.Close()
+
+	c.Set(&models.Order{OrderUID: "a"})
+	_, _, _ = c.Get("a")
+	_, _, _ = c.Get("missing")
+
+	expected := `
+# HELP orders_cache_entries Orders currently held in the cache.
+# TYPE orders_cache_entries gauge
+orders_cache_entries 1
+# HELP orders_cache_hits_total Cache lookups that found a live entry.
+# TYPE orders_cache_hits_total counter
+orders_cache_hits_total 1
+# HELP orders_cache_misses_total Cache lookups that found nothing or an expired entry.
+# TYPE orders_cache_misses_total counter
+orders_cache_misses_total 1
+`
+	err := testutil.CollectAndCompare(NewCacheCollector(c), strings.NewReader(expected),
+		"orders_cache_entries", "orders_cache_hits_total", "orders_cache_misses_total")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,27 @@
+package models
+
+const (
+	BatchCreated = "created"
+	BatchUpdated = "updated"
+	BatchInvalid = "invalid"
+	BatchFailed  = "failed"
+)
+
+// BatchResult is the outcome for the order at Index of a batch. Error is set
+// for invalid and failed orders, Violations for orders failing validation.
+type BatchResult struct {
+	Index      int         `json:"index"`
+	OrderUID   string      `json:"order_uid,omitempty"`
+	Status     string      `json:"status"`
+	Version    int         `json:"version,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+type BatchReport struct {
+	Created int           `json:"created"`
+	Updated int           `json:"updated"`
+	Invalid int           `json:"invalid"`
+	Failed  int           `json:"failed"`
+	Results []BatchResult `json:"results"`
+}
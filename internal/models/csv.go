@@ -0,0 +1,68 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+// CSVHeader names the columns of CSVRows: order fields, then delivery_*,
+// payment_* and item_* columns.
+var CSVHeader = []string{
+	"order_uid", "track_number", "entry", "locale", "internal_signature", "customer_id",
+	"delivery_service", "shardkey", "sm_id", "date_created", "oof_shard", "version",
+	"delivery_name", "delivery_phone", "delivery_zip", "delivery_city", "delivery_address",
+	"delivery_region", "delivery_email",
+	"payment_transaction", "payment_request_id", "payment_currency", "payment_provider",
+	"payment_amount", "payment_dt", "payment_bank", "payment_delivery_cost",
+	"payment_goods_total", "payment_custom_fee",
+	"item_chrt_id", "item_track_number", "item_price", "item_rid", "item_name", "item_sale",
+	"item_size", "item_total_price", "item_nm_id", "item_brand", "item_status",
+}
+
+// CSVRows flattens the order into one row per item, repeating the order,
+// delivery and payment columns. An order without items yields one row with
+// empty item columns.
+func (o *Order) CSVRows() [][]string {
+	d, p := o.Delivery, o.Payment
+	base := []string{
+		csvText(o.OrderUID), csvText(o.TrackNumber), csvText(o.Entry), csvText(o.Locale),
+		csvText(o.InternalSignature), csvText(o.CustomerID), csvText(o.DeliveryService), csvText(o.Shardkey),
+		strconv.Itoa(o.SmID), o.DateCreated.UTC().Format(time.RFC3339), csvText(o.OofShard), strconv.Itoa(o.Version),
+		csvText(d.Name), csvText(d.Phone), csvText(d.Zip), csvText(d.City), csvText(d.Address),
+		csvText(d.Region), csvText(d.Email),
+		csvText(p.Transaction), csvText(p.RequestID), csvText(p.Currency), csvText(p.Provider), strconv.Itoa(p.Amount),
+		strconv.FormatInt(p.PaymentDt, 10), csvText(p.Bank), strconv.Itoa(p.DeliveryCost),
+		strconv.Itoa(p.GoodsTotal), strconv.Itoa(p.CustomFee),
+	}
+
+	if len(o.Items) == 0 {
+		return [][]string{append(base, make([]string, len(CSVHeader)-len(base))...)}
+	}
+
+	rows := make([][]string, 0, len(o.Items))
+	for _, it := range o.Items {
+		row := make([]string, len(base), len(CSVHeader))
+		copy(row, base)
+		row = append(row,
+			strconv.Itoa(it.ChrtID), csvText(it.TrackNumber), strconv.Itoa(it.Price), csvText(it.Rid), csvText(it.Name),
+			strconv.Itoa(it.Sale), csvText(it.Size), strconv.Itoa(it.TotalPrice), strconv.Itoa(it.NmID),
+			csvText(it.Brand), strconv.Itoa(it.Status),
+		)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// csvText prefixes text that a spreadsheet would run as a formula with a
+// single quote, so that it is shown as text instead. Numeric columns are
+// not passed through it and keep their sign.
+func csvText(s string) string {
+	if s == "" {
+		return s
+	}
+	switch s[0] {
+	case '=', '+', '-', '@', '\t', '\r':
+		return "'" + s
+	}
+	return s
+}
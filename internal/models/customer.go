@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// CustomerSummary aggregates all orders of one customer. TotalSpent sums
+// payment amounts per currency.
+type CustomerSummary struct {
+	CustomerID      string         `json:"customer_id"`
+	OrderCount      int            `json:"order_count"`
+	TotalSpent      map[string]int `json:"total_spent"`
+	FirstOrderAt    time.Time      `json:"first_order_at"`
+	LastOrderAt     time.Time      `json:"last_order_at"`
+	FavouriteBrands []BrandCount   `json:"favourite_brands"`
+}
+
+type BrandCount struct {
+	Brand string `json:"brand"`
+	Items int    `json:"items"`
+}
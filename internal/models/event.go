@@ -0,0 +1,23 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	EventOrderCreated = "order.created"
+	EventOrderUpdated = "order.updated"
+	EventOrderDeleted = "order.deleted"
+)
+
+// OutboxEvent is an order event waiting in the outbox to be published.
+// Payload is the order after the change and is empty for deletes.
+type OutboxEvent struct {
+	ID        int64
+	Type      string
+	OrderUID  string
+	Version   int
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+type OrderFilter struct {
+	CustomerID      string
+	TrackNumber     string
+	Transaction     string
+	Rid             string
+	DeliveryService string
+	Brand           string
+	CreatedFrom     time.Time
+	CreatedTo       time.Time
+	After           *OrderCursor
+	Limit           int
+}
+
+// OrderCursor points at the last order of a page; listing is ordered by
+// (date_created, order_uid) descending, so the next page starts strictly after it.
+type OrderCursor struct {
+	DateCreated time.Time `json:"d"`
+	OrderUID    string    `json:"u"`
+}
+
+type OrderPage struct {
+	Orders     []*Order `json:"orders"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+func (c OrderCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeOrderCursor(s string) (*OrderCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c OrderCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.OrderUID == "" || c.DateCreated.IsZero() {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+const (
+	RuleEmail      = "email"
+	RulePhone      = "e164"
+	RulePostalCode = "postal_code"
+	RuleCurrency   = "iso4217"
+	RuleLocale     = "bcp47"
+)
+
+var (
+	e164Pattern       = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+	postalCodePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 -]*[A-Za-z0-9]$`)
+	// language[-script][-region](-variant)*, without extensions or private use
+	bcp47Pattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z]{4})?(-(?:[A-Za-z]{2}|[0-9]{3}))?(-(?:[A-Za-z0-9]{5,8}|[0-9][A-Za-z0-9]{3}))*$`)
+)
+
+// isoCurrencies are the active ISO 4217 alphabetic codes.
+var isoCurrencies = toSet(strings.Fields(`
+AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BHD BIF BMD BND BOB BOV
+BRL BSD BTN BWP BYN BZD CAD CDF CHE CHF CHW CLF CLP CNY COP COU CRC CUC CUP CVE
+CZK DJF DKK DOP DZD EGP ERN ETB EUR FJD FKP GBP GEL GHS GIP GMD GNF GTQ GYD HKD
+HNL HTG HUF IDR ILS INR IQD IRR ISK JMD JOD JPY KES KGS KHR KMF KPW KRW KWD KYD
+KZT LAK LBP LKR LRD LSL LYD MAD MDL MGA MKD MMK MNT MOP MRU MUR MVR MWK MXN MXV
+MYR MZN NAD NGN NIO NOK NPR NZD OMR PAB PEN PGK PHP PKR PLN PYG QAR RON RSD RUB
+RWF SAR SBD SCR SDG SEK SGD SHP SLE SLL SOS SRD SSP STN SVC SYP SZL THB TJS TMT
+TND TOP TRY TTD TWD TZS UAH UGX USD USN UYI UYU UYW UZS VED VES VND VUV WST XAF
+XAG XAU XBA XBB XBC XBD XCD XCG XDR XOF XPD XPF XPT XSU XTS XUA XXX YER ZAR ZMW
+ZWG ZWL
+`))
+
+func toSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+// The format checks below skip empty values, which required reports.
+
+func (v *validator) email(path, value string) {
+	if value == "" {
+		return
+	}
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value || !strings.Contains(value[strings.LastIndex(value, "@"):], ".") {
+		v.add(path, RuleEmail, "must be an email address")
+	}
+}
+
+func (v *validator) phone(path, value string) {
+	if value != "" && !e164Pattern.MatchString(value) {
+		v.add(path, RulePhone, "must be an E.164 phone number, e.g. +79991234567")
+	}
+}
+
+func (v *validator) postalCode(path, value string) {
+	if value != "" && !postalCodePattern.MatchString(value) {
+		v.add(path, RulePostalCode, "must be a postal code of letters, digits, spaces and hyphens")
+	}
+}
+
+func (v *validator) currency(path, value string) {
+	if value == "" {
+		return
+	}
+	if _, ok := isoCurrencies[value]; !ok {
+		v.add(path, RuleCurrency, "must be an ISO 4217 currency code, e.g. RUB")
+	}
+}
+
+func (v *validator) locale(path, value string) {
+	if value != "" && !bcp47Pattern.MatchString(value) {
+		v.add(path, RuleLocale, "must be a BCP 47 language tag, e.g. en or ru-RU")
+	}
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+const (
+	SourceHTTP  = "http"
+	SourceKafka = "kafka"
+)
+
+// ChangeSource tells where a write came from: the Kafka message coordinates
+// or the HTTP request ID.
+type ChangeSource struct {
+	Kind string
+	Ref  string
+	// IdempotencyKey, when set, is stored together with the change, and a
+	// second write carrying the same key is rejected.
+	IdempotencyKey string
+	RequestHash    string
+}
+
+type ProcessedMessage struct {
+	Key         string
+	OrderUID    string
+	RequestHash string
+	ProcessedAt time.Time
+}
+
+type changeSourceKey struct{}
+
+func WithChangeSource(ctx context.Context, src ChangeSource) context.Context {
+	return context.WithValue(ctx, changeSourceKey{}, src)
+}
+
+func ChangeSourceFrom(ctx context.Context) ChangeSource {
+	src, _ := ctx.Value(changeSourceKey{}).(ChangeSource)
+	return src
+}
+
+type orderSourcesKey struct{}
+
+// WithOrderSources sets the change source of each order of a batch write,
+// by order UID.
+func WithOrderSources(ctx context.Context, sources map[string]ChangeSource) context.Context {
+	return context.WithValue(ctx, orderSourcesKey{}, sources)
+}
+
+// OrderSourceFrom returns the change source set for the order by
+// WithOrderSources, or the one of the whole context.
+func OrderSourceFrom(ctx context.Context, orderUID string) ChangeSource {
+	sources, _ := ctx.Value(orderSourcesKey{}).(map[string]ChangeSource)
+	if src, ok := sources[orderUID]; ok {
+		return src
+	}
+	return ChangeSourceFrom(ctx)
+}
+
+// OrderRevision is one entry of an order's change log. Snapshot is the full
+// order after the change and is empty for deletes.
+type OrderRevision struct {
+	Version   int             `json:"version"`
+	Operation string          `json:"operation"`
+	Source    string          `json:"source,omitempty"`
+	SourceRef string          `json:"source_ref,omitempty"`
+	ChangedAt time.Time       `json:"changed_at"`
+	Snapshot  json.RawMessage `json:"snapshot,omitempty"`
+	Changes   []FieldChange   `json:"changes,omitempty"`
+}
+
+type FieldChange struct {
+	Path string `json:"path"`
+	Old  any    `json:"old"`
+	New  any    `json:"new"`
+}
+
+type OrderHistory struct {
+	OrderUID  string          `json:"order_uid"`
+	Revisions []OrderRevision `json:"revisions"`
+}
+
+// DiffJSON compares two JSON documents and lists changed leaves by path,
+// e.g. "delivery.city" or "items[1].price". Paths are sorted.
+func DiffJSON(before, after []byte) ([]FieldChange, error) {
+	var a, b any
+	if err := json.Unmarshal(before, &a); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(after, &b); err != nil {
+		return nil, err
+	}
+	var changes []FieldChange
+	diffValue("", a, b, &changes)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+func diffValue(path string, a, b any, out *[]FieldChange) {
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		for k, v := range av {
+			diffValue(joinPath(path, k), v, bv[k], out)
+		}
+		for k, v := range bv {
+			if _, ok := av[k]; !ok {
+				diffValue(joinPath(path, k), nil, v, out)
+			}
+		}
+		return
+	case []any:
+		bv, ok := b.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < len(av) || i < len(bv); i++ {
+			var x, y any
+			if i < len(av) {
+				x = av[i]
+			}
+			if i < len(bv) {
+				y = bv[i]
+			}
+			diffValue(path+"["+strconv.Itoa(i)+"]", x, y, out)
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*out = append(*out, FieldChange{Path: path, Old: a, New: b})
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -1,9 +1,8 @@
 package models
 
 import (
-	"errors"
+	"encoding/json"
 	"time"
-	"unicode/utf8"
 )
 
 type Order struct {
@@ -21,6 +20,15 @@ type Order struct {
 	SmID              int       `json:"sm_id"`
 	DateCreated       time.Time `json:"date_created"`
 	OofShard          string    `json:"oof_shard"`
+	Version           int       `json:"version,omitempty"`
+
+	// Warnings are the violations of business rules in warn mode, found when
+	// the order was last written.
+	Warnings []Violation `json:"warnings,omitempty"`
+
+	// RawPayload is the document the order was received as; it is stored
+	// alongside the order but never serialized or cached.
+	RawPayload json.RawMessage `json:"-"`
 }
 
 type Delivery struct {
@@ -61,102 +69,100 @@ type Item struct {
 	Status      int    `json:"status"`
 }
 
+// Validate checks the order against the required-field, range, format and
+// column-length rules and returns a *ValidationError listing every violation
+// found.
 func (o *Order) Validate() error {
-	if o.OrderUID == "" {
-		return errors.New("order_uid is required")
-	}
-	if utf8.RuneCountInString(o.OrderUID) > 50 {
-		return errors.New("order_uid too long")
-	}
-	if o.TrackNumber == "" {
-		return errors.New("track_number is required")
-	}
-	if o.Entry == "" {
-		return errors.New("entry is required")
-	}
-	if o.CustomerID == "" {
-		return errors.New("customer_id is required")
-	}
-	if o.DeliveryService == "" {
-		return errors.New("delivery_service is required")
-	}
+	var v validator
+
+	v.required("order_uid", o.OrderUID)
+	v.required("track_number", o.TrackNumber)
+	v.required("entry", o.Entry)
+	v.required("locale", o.Locale)
+	v.required("customer_id", o.CustomerID)
+	v.required("delivery_service", o.DeliveryService)
 	if o.DateCreated.IsZero() {
-		return errors.New("date_created is required and must be valid datetime")
+		v.add("date_created", RuleRequired, "is required and must be valid datetime")
 	}
+	v.nonNegative("sm_id", o.SmID)
+	v.locale("locale", o.Locale)
 
-	if o.Delivery.Name == "" {
-		return errors.New("delivery.name is required")
-	}
-	if o.Delivery.Phone == "" {
-		return errors.New("delivery.phone is required")
-	}
-	if o.Delivery.Zip == "" {
-		return errors.New("delivery.zip is required")
-	}
-	if o.Delivery.City == "" {
-		return errors.New("delivery.city is required")
-	}
-	if o.Delivery.Address == "" {
-		return errors.New("delivery.address is required")
-	}
-	if o.Delivery.Region == "" {
-		return errors.New("delivery.region is required")
-	}
-	if o.Delivery.Email == "" {
-		return errors.New("delivery.email is required")
-	}
+	v.required("delivery.name", o.Delivery.Name)
+	v.required("delivery.phone", o.Delivery.Phone)
+	v.required("delivery.zip", o.Delivery.Zip)
+	v.required("delivery.city", o.Delivery.City)
+	v.required("delivery.address", o.Delivery.Address)
+	v.required("delivery.region", o.Delivery.Region)
+	v.required("delivery.email", o.Delivery.Email)
+	v.phone("delivery.phone", o.Delivery.Phone)
+	v.postalCode("delivery.zip", o.Delivery.Zip)
+	v.email("delivery.email", o.Delivery.Email)
 
-	if o.Payment.Transaction == "" {
-		return errors.New("payment.transaction is required")
-	}
-	if o.Payment.Currency == "" {
-		return errors.New("payment.currency is required")
-	}
-	if o.Payment.Provider == "" {
-		return errors.New("payment.provider is required")
-	}
-	if o.Payment.Bank == "" {
-		return errors.New("payment.bank is required")
-	}
+	v.required("payment.transaction", o.Payment.Transaction)
+	v.required("payment.currency", o.Payment.Currency)
+	v.required("payment.provider", o.Payment.Provider)
+	v.required("payment.bank", o.Payment.Bank)
+	v.currency("payment.currency", o.Payment.Currency)
 
 	if len(o.Items) == 0 {
-		return errors.New("items cannot be empty")
-	}
-	for _, item := range o.Items {
-		if item.TrackNumber == "" {
-			return errors.New("items.track_number is required")
-		}
-		if item.Name == "" {
-			return errors.New("items.name is required")
-		}
-		if item.Brand == "" {
-			return errors.New("items.brand is required")
-		}
-		if item.Rid == "" {
-			return errors.New("items.rid is required")
-		}
-		if item.Size == "" {
-			return errors.New("items.size is required")
-		}
-		if item.Price <= 0 {
-			return errors.New("items.price must be positive")
-		}
-		if item.TotalPrice <= 0 {
-			return errors.New("items.total_price must be positive")
-		}
-		if item.ChrtID <= 0 {
-			return errors.New("items.chrt_id must be positive")
-		}
-		if item.NmID <= 0 {
-			return errors.New("items.nm_id must be positive")
-		}
-		if item.Status < 0 {
-			return errors.New("items.status cannot be negative")
-		}
-		if item.Sale < 0 {
-			return errors.New("items.sale cannot be negative")
+		v.add("items", RuleRequired, "cannot be empty")
+	}
+	// items are unique by chrt_id within an order, as in the items table
+	chrtIDs := make(map[int]int, len(o.Items))
+	for i, item := range o.Items {
+		if first, ok := chrtIDs[item.ChrtID]; ok && item.ChrtID > 0 {
+			v.add(itemPath(i, "chrt_id"), RuleUnique, "duplicates "+itemPath(first, "chrt_id"))
+		} else if !ok {
+			chrtIDs[item.ChrtID] = i
 		}
+		v.required(itemPath(i, "track_number"), item.TrackNumber)
+		v.required(itemPath(i, "name"), item.Name)
+		v.required(itemPath(i, "brand"), item.Brand)
+		v.required(itemPath(i, "rid"), item.Rid)
+		v.required(itemPath(i, "size"), item.Size)
+		v.positive(itemPath(i, "price"), item.Price)
+		v.positive(itemPath(i, "total_price"), item.TotalPrice)
+		v.positive(itemPath(i, "chrt_id"), item.ChrtID)
+		v.positive(itemPath(i, "nm_id"), item.NmID)
+		v.nonNegative(itemPath(i, "status"), item.Status)
+		v.nonNegative(itemPath(i, "sale"), item.Sale)
 	}
 
-	return nil
+	o.validateLengths(&v)
+	return v.err()
+}
+
+// validateLengths mirrors the VARCHAR sizes of the columns in migrations/, so
+// an oversized field is reported here rather than by Postgres.
+func (o *Order) validateLengths(v *validator) {
+	v.maxLength("order_uid", o.OrderUID, 50)
+	v.maxLength("track_number", o.TrackNumber, 50)
+	v.maxLength("entry", o.Entry, 10)
+	v.maxLength("locale", o.Locale, 10)
+	v.maxLength("internal_signature", o.InternalSignature, 255)
+	v.maxLength("customer_id", o.CustomerID, 50)
+	v.maxLength("delivery_service", o.DeliveryService, 100)
+	v.maxLength("shardkey", o.Shardkey, 50)
+	v.maxLength("oof_shard", o.OofShard, 50)
+
+	v.maxLength("delivery.name", o.Delivery.Name, 200)
+	v.maxLength("delivery.phone", o.Delivery.Phone, 50)
+	v.maxLength("delivery.zip", o.Delivery.Zip, 50)
+	v.maxLength("delivery.city", o.Delivery.City, 200)
+	v.maxLength("delivery.address", o.Delivery.Address, 400)
+	v.maxLength("delivery.region", o.Delivery.Region, 200)
+	v.maxLength("delivery.email", o.Delivery.Email, 200)
+
+	v.maxLength("payment.transaction", o.Payment.Transaction, 200)
+	v.maxLength("payment.request_id", o.Payment.RequestID, 200)
+	v.maxLength("payment.currency", o.Payment.Currency, 10)
+	v.maxLength("payment.provider", o.Payment.Provider, 200)
+	v.maxLength("payment.bank", o.Payment.Bank, 200)
+
+	for i, item := range o.Items {
+		v.maxLength(itemPath(i, "track_number"), item.TrackNumber, 50)
+		v.maxLength(itemPath(i, "rid"), item.Rid, 200)
+		v.maxLength(itemPath(i, "size"), item.Size, 50)
+		v.maxLength(itemPath(i, "brand"), item.Brand, 200)
+	}
 }
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -19,6 +20,7 @@ func TestOrder_Validate(t *testing.T) {
 				OrderUID:        "test123",
 				TrackNumber:     "TRACK123",
 				Entry:           "WBIL",
+				Locale:          "en",
 				CustomerID:      "test_customer",
 				DeliveryService: "meest",
 				DateCreated:     time.Now(),
@@ -78,3 +80,228 @@ func TestOrder_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestOrderCursor_RoundTrip(t *testing.T) {
+	c := OrderCursor{DateCreated: time.Date(2021, time.November, 26, 6, 22, 19, 0, time.UTC), OrderUID: "b563feb7b2b84b6test"}
+
+	decoded, err := DecodeOrderCursor(c.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, c.OrderUID, decoded.OrderUID)
+	assert.True(t, c.DateCreated.Equal(decoded.DateCreated))
+
+	_, err = DecodeOrderCursor("not-a-cursor")
+	assert.Error(t, err)
+}
+
+func TestMergePatch(t *testing.T) {
+	doc := []byte(`{"a":1,"b":{"c":"x","d":"y"},"items":[1,2]}`)
+
+	out, err := MergePatch(doc, []byte(`{"a":2,"b":{"c":null,"e":"z"},"items":[3]}`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"a":2,"b":{"d":"y","e":"z"},"items":[3]}`, string(out))
+
+	_, err = MergePatch(doc, []byte(`{`))
+	assert.Error(t, err)
+}
+
+func TestDiffJSON(t *testing.T) {
+	before := []byte(`{"entry":"WBIL","delivery":{"city":"A"},"items":[{"price":1},{"price":2}]}`)
+	after := []byte(`{"entry":"WBIL","delivery":{"city":"B"},"items":[{"price":1}],"locale":"en"}`)
+
+	changes, err := DiffJSON(before, after)
+	assert.NoError(t, err)
+	assert.Equal(t, []FieldChange{
+		{Path: "delivery.city", Old: "A", New: "B"},
+		{Path: "items[1]", Old: map[string]any{"price": float64(2)}, New: nil},
+		{Path: "locale", Old: nil, New: "en"},
+	}, changes)
+}
+
+func TestOrder_CSVRows(t *testing.T) {
+	order := Order{
+		OrderUID:    "test123",
+		DateCreated: time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC),
+		Payment:     Payment{Currency: "USD", Amount: 1817},
+		Items: []Item{
+			{ChrtID: 1, Name: "Mascaras", Price: 453},
+			{ChrtID: 2, Name: "Lipstick", Price: 100},
+		},
+	}
+
+	rows := order.CSVRows()
+	assert.Len(t, rows, 2)
+	for _, row := range rows {
+		assert.Len(t, row, len(CSVHeader))
+		assert.Equal(t, "test123", row[0])
+		assert.Equal(t, "2021-11-26T06:22:19Z", row[9])
+		assert.Equal(t, "1817", row[23])
+	}
+	assert.Equal(t, "Mascaras", rows[0][33])
+	assert.Equal(t, "Lipstick", rows[1][33])
+
+	order.Items = nil
+	rows = order.CSVRows()
+	assert.Len(t, rows, 1)
+	assert.Len(t, rows[0], len(CSVHeader))
+	assert.Empty(t, rows[0][len(CSVHeader)-1])
+}
+
+func TestOrder_CSVRowsEscapesFormulas(t *testing.T) {
+	order := Order{
+		OrderUID: "test123",
+		Delivery: Delivery{Name: "=HYPERLINK(\"http://evil\")", Phone: "+79991234567", City: "@SUM(A1)"},
+		Payment:  Payment{Amount: -5},
+		Items:    []Item{{Name: "-1+1", Brand: "Vivienne Sabo"}},
+	}
+
+	row := order.CSVRows()[0]
+	assert.Equal(t, "'=HYPERLINK(\"http://evil\")", row[12])
+	assert.Equal(t, "'+79991234567", row[13])
+	assert.Equal(t, "'@SUM(A1)", row[15])
+	assert.Equal(t, "-5", row[23])
+	assert.Equal(t, "'-1+1", row[33])
+	assert.Equal(t, "Vivienne Sabo", row[38])
+}
+
+func TestOrder_Validate_CollectsAllViolations(t *testing.T) {
+	order := Order{
+		OrderUID: "test123",
+		Items: []Item{
+			{Name: "ok", Brand: "b", Rid: "r", Size: "0", TrackNumber: "T", Price: 1, TotalPrice: 1, ChrtID: 1, NmID: 1},
+			{Name: "bad", Brand: "b", Rid: "r", Size: "0", TrackNumber: "T", Price: 0, TotalPrice: 1, ChrtID: 1, NmID: 1, Sale: -1},
+		},
+	}
+
+	err := order.Validate()
+	var verr *ValidationError
+	if !assert.ErrorAs(t, err, &verr) {
+		return
+	}
+
+	paths := make(map[string]string, len(verr.Violations))
+	for _, v := range verr.Violations {
+		paths[v.Path] = v.Rule
+	}
+	assert.Equal(t, RuleRequired, paths["track_number"])
+	assert.Equal(t, RuleRequired, paths["delivery.email"])
+	assert.Equal(t, RulePositive, paths["items[1].price"])
+	assert.Equal(t, RuleNonNegative, paths["items[1].sale"])
+	assert.Equal(t, RuleUnique, paths["items[1].chrt_id"])
+	assert.NotContains(t, paths, "items[0].price")
+	assert.NotContains(t, paths, "items[0].chrt_id")
+	assert.Contains(t, err.Error(), "items[1].price must be positive")
+	assert.Contains(t, err.Error(), "items[1].chrt_id duplicates items[0].chrt_id")
+}
+
+func TestRuleEngine_Check(t *testing.T) {
+	order := Order{
+		OrderUID:    "b563feb7b2b84b6test",
+		TrackNumber: "WBILMTESTTRACK",
+		Payment: Payment{
+			Transaction:  "b563feb7b2b84b6test",
+			Amount:       1817,
+			DeliveryCost: 1500,
+			GoodsTotal:   317,
+		},
+		Items: []Item{{TrackNumber: "WBILMTESTTRACK", Price: 453, Sale: 30, TotalPrice: 317}},
+	}
+
+	engine := DefaultRuleEngine()
+	warnings, err := engine.Check(&order)
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	// a payment ID derived from the order relates to it as well
+	order.Payment.Transaction = "pay-b563feb7b2b84b6test-1"
+	warnings, err = engine.Check(&order)
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	order.Items[0].TotalPrice = 400
+	order.Items[0].TrackNumber = "OTHER"
+	order.Payment.Transaction = "other"
+	warnings, err = engine.Check(&order)
+	assert.NoError(t, err)
+	rules := make([]string, len(warnings))
+	for i, w := range warnings {
+		rules[i] = w.Rule
+	}
+	assert.Equal(t, []string{RuleGoodsTotal, RuleItemTotalPrice, RuleItemTrackNumber, RuleTransaction}, rules)
+
+	assert.NoError(t, engine.SetModes([]string{"item_total_price=strict", "transaction=off"}))
+	warnings, err = engine.Check(&order)
+	var verr *ValidationError
+	if assert.ErrorAs(t, err, &verr) {
+		assert.Equal(t, []Violation{{
+			Path:    "items[0].total_price",
+			Rule:    RuleItemTotalPrice,
+			Message: "must equal price minus sale percent (317)",
+		}}, verr.Violations)
+	}
+	assert.Len(t, warnings, 2)
+
+	assert.Error(t, engine.SetModes([]string{"unknown=strict"}))
+	assert.Error(t, engine.SetModes([]string{"amount=loud"}))
+
+	engine.Register(Rule{Name: "custom", Check: func(o *Order) []Violation {
+		return []Violation{{Path: "entry", Message: "is not allowed"}}
+	}}, RuleWarn)
+	warnings, _ = engine.Check(&order)
+	assert.Equal(t, "custom", warnings[len(warnings)-1].Rule)
+}
+
+func TestOrder_Validate_Formats(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(o *Order)
+		path   string
+		rule   string
+	}{
+		{"email without domain", func(o *Order) { o.Delivery.Email = "test@" }, "delivery.email", RuleEmail},
+		{"email with display name", func(o *Order) { o.Delivery.Email = "Test <test@gmail.com>" }, "delivery.email", RuleEmail},
+		{"phone without plus", func(o *Order) { o.Delivery.Phone = "89991234567" }, "delivery.phone", RulePhone},
+		{"phone too long", func(o *Order) { o.Delivery.Phone = "+1234567890123456" }, "delivery.phone", RulePhone},
+		{"zip with symbols", func(o *Order) { o.Delivery.Zip = "26#398" }, "delivery.zip", RulePostalCode},
+		{"unknown currency", func(o *Order) { o.Payment.Currency = "usd" }, "payment.currency", RuleCurrency},
+		{"underscore locale", func(o *Order) { o.Locale = "en_US" }, "locale", RuleLocale},
+		{"missing locale", func(o *Order) { o.Locale = "" }, "locale", RuleRequired},
+		{"entry longer than column", func(o *Order) { o.Entry = "WBILWBILWBIL" }, "entry", RuleMaxLength},
+		{"item rid longer than column", func(o *Order) { o.Items[0].Rid = strings.Repeat("r", 201) }, "items[0].rid", RuleMaxLength},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := formatTestOrder()
+			tt.modify(&order)
+
+			var verr *ValidationError
+			if assert.ErrorAs(t, order.Validate(), &verr) {
+				assert.Len(t, verr.Violations, 1)
+				assert.Equal(t, tt.path, verr.Violations[0].Path)
+				assert.Equal(t, tt.rule, verr.Violations[0].Rule)
+			}
+		})
+	}
+
+	order := formatTestOrder()
+	order.Locale = "ru-RU"
+	order.Delivery.Zip = "SW1A 1AA"
+	order.Payment.Currency = "RUB"
+	assert.NoError(t, order.Validate())
+}
+
+func formatTestOrder() Order {
+	return Order{
+		OrderUID: "test123", TrackNumber: "TRACK123", Entry: "WBIL", Locale: "en",
+		CustomerID: "test_customer", DeliveryService: "meest", DateCreated: time.Now(),
+		Delivery: Delivery{
+			Name: "Test Testov", Phone: "+9720000000", Zip: "2639809", City: "Kiryat Mozkin",
+			Address: "Ploshad Mira 15", Region: "Kraiot", Email: "test@gmail.com",
+		},
+		Payment: Payment{Transaction: "test123", Currency: "USD", Provider: "wbpay", Bank: "alpha"},
+		Items: []Item{{
+			ChrtID: 9934930, TrackNumber: "TRACK123", Price: 453, Rid: "ab4219087a764ae0btest", Name: "Mascaras",
+			Size: "0", TotalPrice: 317, NmID: 2389212, Brand: "Vivienne Sabo", Status: 202,
+		}},
+	}
+}
@@ -0,0 +1,194 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type RuleMode string
+
+const (
+	// RuleOff skips the rule, RuleWarn records its violations as warnings of
+	// the order and RuleStrict rejects the order.
+	RuleOff    RuleMode = "off"
+	RuleWarn   RuleMode = "warn"
+	RuleStrict RuleMode = "strict"
+)
+
+// Names of the built-in business rules, also used as the rule of their
+// violations.
+const (
+	RuleGoodsTotal      = "goods_total"
+	RuleAmount          = "amount"
+	RuleItemTotalPrice  = "item_total_price"
+	RuleItemTrackNumber = "item_track_number"
+	RuleTransaction     = "transaction"
+)
+
+// Rule is a consistency check between fields of an order that passed
+// Validate. Violations returned without a rule get the rule's name.
+type Rule struct {
+	Name  string
+	Check func(o *Order) []Violation
+}
+
+// RuleEngine runs business rules, each in its own mode. It is configured at
+// startup and only read afterwards, so it is not guarded by a lock.
+type RuleEngine struct {
+	rules []Rule
+	modes map[string]RuleMode
+}
+
+func NewRuleEngine() *RuleEngine {
+	return &RuleEngine{modes: make(map[string]RuleMode)}
+}
+
+// DefaultRuleEngine has the built-in rules registered in warn mode, so
+// existing producers keep working while inconsistencies are recorded.
+func DefaultRuleEngine() *RuleEngine {
+	e := NewRuleEngine()
+	e.Register(Rule{Name: RuleGoodsTotal, Check: checkGoodsTotal}, RuleWarn)
+	e.Register(Rule{Name: RuleAmount, Check: checkAmount}, RuleWarn)
+	e.Register(Rule{Name: RuleItemTotalPrice, Check: checkItemTotalPrice}, RuleWarn)
+	e.Register(Rule{Name: RuleItemTrackNumber, Check: checkItemTrackNumber}, RuleWarn)
+	e.Register(Rule{Name: RuleTransaction, Check: checkTransaction}, RuleWarn)
+	return e
+}
+
+// Register adds a rule, replacing a registered rule of the same name.
+func (e *RuleEngine) Register(rule Rule, mode RuleMode) {
+	if _, ok := e.modes[rule.Name]; ok {
+		for i := range e.rules {
+			if e.rules[i].Name == rule.Name {
+				e.rules[i] = rule
+			}
+		}
+	} else {
+		e.rules = append(e.rules, rule)
+	}
+	e.modes[rule.Name] = mode
+}
+
+func (e *RuleEngine) SetMode(name string, mode RuleMode) error {
+	if _, ok := e.modes[name]; !ok {
+		return fmt.Errorf("unknown rule %q", name)
+	}
+	switch mode {
+	case RuleOff, RuleWarn, RuleStrict:
+	default:
+		return fmt.Errorf("invalid mode %q for rule %q", mode, name)
+	}
+	e.modes[name] = mode
+	return nil
+}
+
+// SetModes applies specs of the form "rule=mode", e.g. "amount=strict".
+func (e *RuleEngine) SetModes(specs []string) error {
+	for _, spec := range specs {
+		name, mode, ok := strings.Cut(spec, "=")
+		if !ok {
+			return fmt.Errorf("invalid rule mode %q, want rule=mode", spec)
+		}
+		if err := e.SetMode(strings.TrimSpace(name), RuleMode(strings.TrimSpace(mode))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check runs every rule that is not off. Violations of strict rules are
+// returned as a *ValidationError, those of warn rules as warnings.
+func (e *RuleEngine) Check(o *Order) (warnings []Violation, err error) {
+	var strict []Violation
+	for _, rule := range e.rules {
+		mode := e.modes[rule.Name]
+		if mode == RuleOff {
+			continue
+		}
+		for _, v := range rule.Check(o) {
+			if v.Rule == "" {
+				v.Rule = rule.Name
+			}
+			if mode == RuleStrict {
+				strict = append(strict, v)
+			} else {
+				warnings = append(warnings, v)
+			}
+		}
+	}
+	if len(strict) > 0 {
+		return warnings, &ValidationError{Violations: strict}
+	}
+	return warnings, nil
+}
+
+func checkGoodsTotal(o *Order) []Violation {
+	sum := 0
+	for _, item := range o.Items {
+		sum += item.TotalPrice
+	}
+	if o.Payment.GoodsTotal == sum {
+		return nil
+	}
+	return []Violation{{
+		Path:    "payment.goods_total",
+		Message: "must equal the sum of items total_price (" + strconv.Itoa(sum) + ")",
+	}}
+}
+
+func checkAmount(o *Order) []Violation {
+	p := o.Payment
+	want := p.GoodsTotal + p.DeliveryCost + p.CustomFee
+	if p.Amount == want {
+		return nil
+	}
+	return []Violation{{
+		Path:    "payment.amount",
+		Message: "must equal goods_total + delivery_cost + custom_fee (" + strconv.Itoa(want) + ")",
+	}}
+}
+
+// checkItemTotalPrice allows the discounted price to be rounded either way.
+func checkItemTotalPrice(o *Order) []Violation {
+	var out []Violation
+	for i, item := range o.Items {
+		discounted := item.Price * (100 - item.Sale)
+		low, high := discounted/100, (discounted+99)/100
+		if item.TotalPrice >= low && item.TotalPrice <= high {
+			continue
+		}
+		out = append(out, Violation{
+			Path:    itemPath(i, "total_price"),
+			Message: "must equal price minus sale percent (" + strconv.Itoa(low) + ")",
+		})
+	}
+	return out
+}
+
+func checkItemTrackNumber(o *Order) []Violation {
+	var out []Violation
+	for i, item := range o.Items {
+		if item.TrackNumber != o.TrackNumber {
+			out = append(out, Violation{
+				Path:    itemPath(i, "track_number"),
+				Message: "must equal the order track_number",
+			})
+		}
+	}
+	return out
+}
+
+// checkTransaction only asks the transaction to relate to the order by
+// containing its order_uid: producers use the order_uid itself or derive the
+// payment ID from it, e.g. "pay-<order_uid>-1". A producer with payment IDs
+// unrelated to the order can't be checked at all and should turn the rule off.
+func checkTransaction(o *Order) []Violation {
+	if strings.Contains(o.Payment.Transaction, o.OrderUID) {
+		return nil
+	}
+	return []Violation{{
+		Path:    "payment.transaction",
+		Message: "must contain order_uid",
+	}}
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+const (
+	BucketHour = "hour"
+	BucketDay  = "day"
+	BucketWeek = "week"
+)
+
+// StatsFilter limits statistics to orders created in [From, To). Bucket is
+// used by order volume only, Limit by the top lists only.
+type StatsFilter struct {
+	From     time.Time
+	To       time.Time
+	Bucket   string
+	Currency string
+	Limit    int
+}
+
+// OrderVolume is the number of orders and their payment total in one time
+// bucket and currency.
+type OrderVolume struct {
+	Bucket   time.Time `json:"bucket"`
+	Currency string    `json:"currency"`
+	Orders   int       `json:"orders"`
+	Amount   int64     `json:"amount"`
+}
+
+// Sales counts sold items and sums their total_price per payment currency;
+// Brand or NmID tells what was sold.
+type Sales struct {
+	Brand   string           `json:"brand,omitempty"`
+	NmID    int              `json:"nm_id,omitempty"`
+	Name    string           `json:"name,omitempty"`
+	Items   int              `json:"items"`
+	Revenue map[string]int64 `json:"revenue"`
+}
+
+type DeliveryShare struct {
+	DeliveryService string  `json:"delivery_service"`
+	Orders          int     `json:"orders"`
+	Share           float64 `json:"share"`
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	RuleRequired    = "required"
+	RuleMaxLength   = "max_length"
+	RulePositive    = "positive"
+	RuleNonNegative = "non_negative"
+	RuleUnique      = "unique"
+)
+
+// Violation is one broken rule, located by the JSON path of the offending
+// field, e.g. "items[2].price".
+type Violation struct {
+	Path    string `json:"path"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every violation found in an order, not just the
+// first one.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Path + " " + v.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+type validator struct {
+	violations []Violation
+}
+
+func (v *validator) add(path, rule, message string) {
+	v.violations = append(v.violations, Violation{Path: path, Rule: rule, Message: message})
+}
+
+func (v *validator) required(path, value string) {
+	if value == "" {
+		v.add(path, RuleRequired, "is required")
+	}
+}
+
+func (v *validator) maxLength(path, value string, max int) {
+	if utf8.RuneCountInString(value) > max {
+		v.add(path, RuleMaxLength, "must be at most "+strconv.Itoa(max)+" characters")
+	}
+}
+
+func (v *validator) positive(path string, value int) {
+	if value <= 0 {
+		v.add(path, RulePositive, "must be positive")
+	}
+}
+
+func (v *validator) nonNegative(path string, value int) {
+	if value < 0 {
+		v.add(path, RuleNonNegative, "cannot be negative")
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.violations) == 0 {
+		return nil
+	}
+	return &ValidationError{Violations: v.violations}
+}
+
+func itemPath(i int, field string) string {
+	return "items[" + strconv.Itoa(i) + "]." + field
+}
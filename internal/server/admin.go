@@ -0,0 +1,170 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"wb-tech-1task/internal/kafka"
+)
+
+type AdminHandler struct {
+	deadLetters *kafka.DeadLetterReplayer
+	logger      *zap.Logger
+}
+
+func NewAdminHandler(deadLetters *kafka.DeadLetterReplayer, logger *zap.Logger) *AdminHandler {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &AdminHandler{
+		deadLetters: deadLetters,
+		logger:      logger,
+	}
+}
+
+func (h *AdminHandler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseDeadLetterFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	list, err := h.deadLetters.List(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		h.logger.Error("error on listing dead letters", zap.Error(err))
+		return
+	}
+	if list == nil {
+		list = []kafka.DeadLetter{}
+	}
+	writeJSON(w, http.StatusOK, list, h.logger)
+}
+
+func (h *AdminHandler) GetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	partition, err := strconv.Atoi(chi.URLParam(r, "partition"))
+	if err != nil {
+		http.Error(w, "Invalid partition", http.StatusBadRequest)
+		return
+	}
+	offset, err := strconv.ParseInt(chi.URLParam(r, "offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+
+	dl, err := h.deadLetters.Get(r.Context(), partition, offset)
+	if err != nil {
+		if errors.Is(err, kafka.ErrDeadLetterNotFound) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			h.logger.Error("error on getting dead letter", zap.Int("partition", partition), zap.Int64("offset", offset), zap.Error(err))
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, dl, h.logger)
+}
+
+func (h *AdminHandler) ReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseDeadLetterFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	mode := q.Get("mode")
+	if mode == "" {
+		mode = kafka.ReplayPublish
+	}
+	if mode != kafka.ReplayPublish && mode != kafka.ReplaySave {
+		http.Error(w, "Invalid mode", http.StatusBadRequest)
+		return
+	}
+	dryRun := false
+	if v := q.Get("dry_run"); v != "" {
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Invalid dry_run", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := h.deadLetters.Replay(r.Context(), filter, mode, dryRun)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		h.logger.Error("error on replaying dead letters", zap.String("mode", mode), zap.Error(err))
+		return
+	}
+	h.logger.Info("dead letters replayed",
+		zap.String("mode", mode),
+		zap.Bool("dry_run", dryRun),
+		zap.Int("matched", result.Matched),
+		zap.Int("replayed", result.Replayed),
+		zap.Int("failed", result.Failed),
+	)
+	writeJSON(w, http.StatusOK, result, h.logger)
+}
+
+func parseDeadLetterFilter(r *http.Request) (kafka.DeadLetterFilter, error) {
+	q := r.URL.Query()
+	filter := kafka.DeadLetterFilter{
+		ErrorContains: q.Get("error"),
+		Key:           q.Get("key"),
+	}
+	if v := q.Get("partition"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, errors.New("Invalid partition")
+		}
+		filter.Partition = &p
+	}
+	if v := q.Get("offset"); v != "" {
+		o, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return filter, errors.New("Invalid offset")
+		}
+		filter.Offset = &o
+	}
+	if v := q.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 0 {
+			return filter, errors.New("Invalid limit")
+		}
+		filter.Limit = l
+	}
+	return filter, nil
+}
+
+// adminAuth requires "Authorization: Bearer <token>". Without a configured
+// token the admin endpoints are disabled.
+func adminAuth(token string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				http.Error(w, "Admin endpoints are disabled", http.StatusForbidden)
+				return
+			}
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any, logger *zap.Logger) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("failed to encode response", zap.Error(err))
+	}
+}
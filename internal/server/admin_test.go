@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	segkafka "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"wb-tech-1task/internal/kafka"
+)
+
+type staticSource []segkafka.Message
+
+func (s staticSource) Scan(ctx context.Context, fn func(msg segkafka.Message) error) error {
+	for _, m := range s {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s staticSource) Read(ctx context.Context, partition int, offset int64) (segkafka.Message, error) {
+	for _, m := range s {
+		if m.Partition == partition && m.Offset == offset {
+			return m, nil
+		}
+	}
+	return segkafka.Message{}, kafka.ErrDeadLetterNotFound
+}
+
+type recordingWriter struct {
+	written []segkafka.Message
+}
+
+func (w *recordingWriter) WriteMessages(ctx context.Context, msgs ...segkafka.Message) error {
+	w.written = append(w.written, msgs...)
+	return nil
+}
+
+func (w *recordingWriter) Close() error { return nil }
+
+func newTestAdminRouter(token string, w *recordingWriter) http.Handler {
+	source := staticSource{
+		{Partition: 0, Offset: 7, Key: []byte("k1"), Value: []byte(`{}`),
+			Headers: []segkafka.Header{{Key: kafka.HeaderError, Value: []byte("db down")}}},
+	}
+	return NewRouter(nil, RouterOptions{
+		DeadLetters: kafka.NewDeadLetterReplayerFrom(source, w, nil),
+		AdminToken:  token,
+	}, zap.NewNop())
+}
+
+func adminRequest(method, target string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	return req
+}
+
+func TestAdmin_ListDeadLetters(t *testing.T) {
+	router := newTestAdminRouter("secret", &recordingWriter{})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, adminRequest("GET", "/admin/dlq?error=db"))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list []kafka.DeadLetter
+	json.NewDecoder(w.Body).Decode(&list)
+	assert.Len(t, list, 1)
+	assert.Equal(t, "db down", list[0].Error)
+}
+
+func TestAdmin_GetDeadLetterNotFound(t *testing.T) {
+	router := newTestAdminRouter("secret", &recordingWriter{})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, adminRequest("GET", "/admin/dlq/0/8"))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdmin_ReplayDryRun(t *testing.T) {
+	writer := &recordingWriter{}
+	router := newTestAdminRouter("secret", writer)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, adminRequest("POST", "/admin/dlq/replay?dry_run=true"))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var result kafka.ReplayResult
+	json.NewDecoder(w.Body).Decode(&result)
+	assert.True(t, result.DryRun)
+	assert.Equal(t, 1, result.Matched)
+	assert.Empty(t, writer.written)
+}
+
+func TestAdmin_RequiresToken(t *testing.T) {
+	router := newTestAdminRouter("secret", &recordingWriter{})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/dlq", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req := httptest.NewRequest("GET", "/admin/dlq", nil)
+	req.Header.Set("Authorization", "secret")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req = httptest.NewRequest("GET", "/admin/dlq", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAdmin_DisabledWithoutToken(t *testing.T) {
+	router := newTestAdminRouter("", &recordingWriter{})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/admin/dlq/replay", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
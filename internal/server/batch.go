@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"wb-tech-1task/internal/service"
+)
+
+const maxBatchBodyBytes = 32 << 20
+
+// ImportOrders creates or replaces many orders at once. The body is either a
+// JSON array of orders or NDJSON, one order per line; the response lists the
+// outcome of every order by its position in the body.
+func (h *Handler) ImportOrders(w http.ResponseWriter, r *http.Request) {
+	docs, err := decodeBatchBody(http.MaxBytesReader(w, r.Body, maxBatchBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		}
+		return
+	}
+	if len(docs) == 0 {
+		http.Error(w, "No orders in request body", http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.orderService.ImportOrders(changeSource(r.Context(), r), docs)
+	if err != nil {
+		if errors.Is(err, service.ErrBatchTooLarge) {
+			http.Error(w, "At most "+strconv.Itoa(service.MaxImportOrders)+" orders per batch", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			h.logger.Error("error on importing orders", zap.Int("orders", len(docs)), zap.Error(err))
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, report, h.logger)
+}
+
+// decodeBatchBody splits a JSON array or NDJSON body into order documents.
+// NDJSON lines are not checked here, so a broken line only fails its order.
+func decodeBatchBody(body io.Reader) ([]json.RawMessage, error) {
+	br := bufio.NewReader(body)
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+		if err := br.UnreadByte(); err != nil {
+			return nil, err
+		}
+		if c == '[' {
+			var docs []json.RawMessage
+			if err := json.NewDecoder(br).Decode(&docs); err != nil {
+				return nil, err
+			}
+			return docs, nil
+		}
+		break
+	}
+
+	var docs []json.RawMessage
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 64<<10), maxOrderBodyBytes)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		docs = append(docs, json.RawMessage(bytes.Clone(line)))
+	}
+	return docs, scanner.Err()
+}
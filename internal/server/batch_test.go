@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"wb-tech-1task/internal/models"
+	"wb-tech-1task/internal/service"
+	"wb-tech-1task/internal/service/mocks"
+)
+
+func TestHandler_ImportOrders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := mocks.NewMockOrderCache(ctrl)
+	mockRepo := mocks.NewMockOrderRepository(ctrl)
+	handler := NewHandler(service.NewOrderService(mockCache, mockRepo, zap.NewNop()), zap.NewNop())
+
+	a, b := validTestOrder("a"), validTestOrder("b")
+	docA, _ := json.Marshal(a)
+	docB, _ := json.Marshal(b)
+
+	upsert := func(ctx context.Context, orders []*models.Order) ([]models.BatchResult, error) {
+		assert.Len(t, orders, 2)
+		return []models.BatchResult{
+			{OrderUID: "a", Status: models.BatchCreated, Version: 1},
+			{OrderUID: "b", Status: models.BatchUpdated, Version: 3},
+		}, nil
+	}
+
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"json array", []byte("[" + string(docA) + ",\n" + string(docB) + "]")},
+		{"ndjson", []byte(string(docA) + "\n\n" + string(docB) + "\n{broken\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo.EXPECT().UpsertOrders(gomock.Any(), gomock.Any()).DoAndReturn(upsert)
+			mockCache.EXPECT().Set(gomock.Any()).Return(nil).Times(2)
+
+			w := httptest.NewRecorder()
+			handler.ImportOrders(w, httptest.NewRequest(http.MethodPost, "/orders/batch", bytes.NewReader(tt.body)))
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			var report models.BatchReport
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+			assert.Equal(t, 1, report.Created)
+			assert.Equal(t, 1, report.Updated)
+			assert.Equal(t, "b", report.Results[1].OrderUID)
+			assert.Equal(t, 1, report.Results[1].Index)
+			if tt.name == "ndjson" {
+				assert.Equal(t, 1, report.Invalid)
+				assert.Equal(t, models.BatchInvalid, report.Results[2].Status)
+			}
+		})
+	}
+
+	t.Run("malformed array", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ImportOrders(w, httptest.NewRequest(http.MethodPost, "/orders/batch", strings.NewReader(`[{"order_uid":`)))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("empty body", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ImportOrders(w, httptest.NewRequest(http.MethodPost, "/orders/batch", strings.NewReader(" \n")))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"wb-tech-1task/internal/models"
+)
+
+// ExportOrders streams the orders matching the listing filters as CSV (one
+// row per item) or NDJSON (one order per line). limit caps the number of
+// orders and is unbounded by default. A failure after the first batch can no
+// longer change the status, so it ends NDJSON with an error record and
+// aborts a CSV response, which the client sees as a truncated body.
+func (h *Handler) ExportOrders(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseOrderFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	var (
+		writeBatch func([]*models.Order) error
+		finish     func() error
+		abort      func()
+	)
+	switch format {
+	case "", "csv":
+		format = "csv"
+		cw := csv.NewWriter(w)
+		headerWritten := false
+		writeHeader := func() error {
+			if headerWritten {
+				return nil
+			}
+			headerWritten = true
+			return cw.Write(models.CSVHeader)
+		}
+		writeBatch = func(orders []*models.Order) error {
+			if err := writeHeader(); err != nil {
+				return err
+			}
+			for _, o := range orders {
+				if err := cw.WriteAll(o.CSVRows()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		finish = func() error {
+			if err := writeHeader(); err != nil {
+				return err
+			}
+			cw.Flush()
+			return cw.Error()
+		}
+		abort = func() { panic(http.ErrAbortHandler) }
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		writeBatch = func(orders []*models.Order) error {
+			for _, o := range orders {
+				if err := enc.Encode(o); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		finish = func() error { return nil }
+		abort = func() { _ = enc.Encode(exportError{Error: "export failed"}) }
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	default:
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="orders.`+format+`"`)
+
+	rc := http.NewResponseController(w)
+	started := false
+	err = h.orderService.ExportOrders(r.Context(), filter, func(orders []*models.Order) error {
+		started = true
+		if err := writeBatch(orders); err != nil {
+			return err
+		}
+		// WriteAll already flushed the CSV writer into w
+		_ = rc.Flush()
+		return nil
+	})
+	if err == nil {
+		err = finish()
+	}
+	if err != nil {
+		h.logger.Error("error on exporting orders", zap.String("format", format), zap.Bool("started", started), zap.Error(err))
+		if !started {
+			// nothing is sent yet, so the client can still get a proper error
+			w.Header().Del("Content-Disposition")
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		abort()
+	}
+}
+
+// exportError is the last NDJSON record of an export that failed midway.
+type exportError struct {
+	Error string `json:"error"`
+}
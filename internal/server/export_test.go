@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"wb-tech-1task/internal/models"
+	"wb-tech-1task/internal/service"
+	"wb-tech-1task/internal/service/mocks"
+)
+
+func TestHandler_ExportOrders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := mocks.NewMockOrderCache(ctrl)
+	mockRepo := mocks.NewMockOrderRepository(ctrl)
+	handler := NewHandler(service.NewOrderService(mockCache, mockRepo, zap.NewNop()), zap.NewNop())
+
+	first, second := validTestOrder("a"), validTestOrder("b")
+	stream := func(ctx context.Context, filter models.OrderFilter, batchSize, limit int, fn func([]*models.Order) error) error {
+		assert.Equal(t, "VS", filter.Brand)
+		assert.Equal(t, 2, limit)
+		if err := fn([]*models.Order{&first}); err != nil {
+			return err
+		}
+		return fn([]*models.Order{&second})
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		mockRepo.EXPECT().StreamFilteredOrders(gomock.Any(), gomock.Any(), service.ExportBatchSize, 2, gomock.Any()).DoAndReturn(stream)
+
+		w := httptest.NewRecorder()
+		handler.ExportOrders(w, httptest.NewRequest("GET", "/orders/export?format=csv&brand=VS&limit=2", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+		records, err := csv.NewReader(w.Body).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, records, 3)
+		assert.Equal(t, models.CSVHeader, records[0])
+		assert.Equal(t, "a", records[1][0])
+		assert.Equal(t, "b", records[2][0])
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		mockRepo.EXPECT().StreamFilteredOrders(gomock.Any(), gomock.Any(), service.ExportBatchSize, 2, gomock.Any()).DoAndReturn(stream)
+
+		w := httptest.NewRecorder()
+		handler.ExportOrders(w, httptest.NewRequest("GET", "/orders/export?format=ndjson&brand=VS&limit=2", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		assert.Len(t, lines, 2)
+		var got models.Order
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), &got))
+		assert.Equal(t, "b", got.OrderUID)
+	})
+
+	t.Run("error before the first batch", func(t *testing.T) {
+		mockRepo.EXPECT().StreamFilteredOrders(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(errors.New("db down"))
+
+		w := httptest.NewRecorder()
+		handler.ExportOrders(w, httptest.NewRequest("GET", "/orders/export", nil))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Disposition"))
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ExportOrders(w, httptest.NewRequest("GET", "/orders/export?format=xml", nil))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestRouter_ExportOrdersStreams(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := mocks.NewMockOrderCache(ctrl)
+	mockRepo := mocks.NewMockOrderRepository(ctrl)
+	srv := httptest.NewServer(NewRouter(service.NewOrderService(mockCache, mockRepo, zap.NewNop()), RouterOptions{}, zap.NewNop()))
+	defer srv.Close()
+
+	first, second := validTestOrder("a"), validTestOrder("b")
+
+	t.Run("batches are flushed as they are written", func(t *testing.T) {
+		firstRead := make(chan struct{})
+		mockRepo.EXPECT().StreamFilteredOrders(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, filter models.OrderFilter, batchSize, limit int, fn func([]*models.Order) error) error {
+				if err := fn([]*models.Order{&first}); err != nil {
+					return err
+				}
+				// the second batch only comes once the client got the first
+				select {
+				case <-firstRead:
+				case <-time.After(2 * time.Second):
+					return errors.New("first batch was not flushed")
+				}
+				return fn([]*models.Order{&second})
+			})
+
+		resp, err := http.Get(srv.URL + "/orders/export?format=ndjson")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		lines := bufio.NewScanner(resp.Body)
+		assert.True(t, lines.Scan())
+		assert.Contains(t, lines.Text(), `"order_uid":"a"`)
+		close(firstRead)
+		assert.True(t, lines.Scan())
+		assert.Contains(t, lines.Text(), `"order_uid":"b"`)
+		assert.False(t, lines.Scan())
+	})
+
+	failAfterFirst := func(ctx context.Context, filter models.OrderFilter, batchSize, limit int, fn func([]*models.Order) error) error {
+		if err := fn([]*models.Order{&first}); err != nil {
+			return err
+		}
+		return errors.New("db down")
+	}
+
+	t.Run("ndjson error after the first batch ends with an error record", func(t *testing.T) {
+		mockRepo.EXPECT().StreamFilteredOrders(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(failAfterFirst)
+
+		resp, err := http.Get(srv.URL + "/orders/export?format=ndjson")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		assert.Len(t, lines, 2)
+		assert.JSONEq(t, `{"error":"export failed"}`, lines[1])
+	})
+
+	t.Run("csv error after the first batch aborts the response", func(t *testing.T) {
+		mockRepo.EXPECT().StreamFilteredOrders(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(failAfterFirst)
+
+		resp, err := http.Get(srv.URL + "/orders/export?format=csv")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		assert.Error(t, err)
+	})
+}
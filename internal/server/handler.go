@@ -2,15 +2,24 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"go.uber.org/zap"
 
+	"wb-tech-1task/internal/health"
 	"wb-tech-1task/internal/models"
 	"wb-tech-1task/internal/service"
+	"wb-tech-1task/internal/warmup"
 )
 
 type Handler struct {
@@ -28,8 +37,16 @@ func NewHandler(orderService *service.OrderService, logger *zap.Logger) *Handler
 	}
 }
 
+const (
+	maxOrderBodyBytes    = 1 << 20
+	maxIdempotencyKeyLen = 255
+)
+
 func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
-	orderUID := r.URL.Query().Get("uid")
+	orderUID := chi.URLParam(r, "uid")
+	if orderUID == "" {
+		orderUID = r.URL.Query().Get("uid")
+	}
 	if orderUID == "" {
 		http.Error(w, "OrderUID is required", http.StatusBadRequest)
 		return
@@ -47,51 +64,473 @@ func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setETag(w, order)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(order); err != nil {
 		h.logger.Error("failed to encode order", zap.String("order_uid", orderUID), zap.Error(err))
 	}
 }
 
+func (h *Handler) GetOrdersByTrack(w http.ResponseWriter, r *http.Request) {
+	h.writeLookup(w, r, "track", chi.URLParam(r, "track"), h.orderService.GetOrdersByTrack)
+}
+
+func (h *Handler) GetOrdersByRid(w http.ResponseWriter, r *http.Request) {
+	h.writeLookup(w, r, "rid", chi.URLParam(r, "rid"), h.orderService.GetOrdersByRid)
+}
+
+func (h *Handler) GetOrderByTransaction(w http.ResponseWriter, r *http.Request) {
+	transaction := chi.URLParam(r, "tx")
+	order, err := h.orderService.GetOrderByTransaction(r.Context(), transaction)
+	if err != nil {
+		h.writeLookupError(w, "transaction", transaction, err)
+		return
+	}
+
+	setETag(w, order)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(order); err != nil {
+		h.logger.Error("failed to encode order", zap.String("transaction", transaction), zap.Error(err))
+	}
+}
+
+// writeLookup pages through a lookup with the limit and cursor parameters of
+// ListOrders.
+func (h *Handler) writeLookup(w http.ResponseWriter, r *http.Request, field, key string,
+	find func(context.Context, string, models.OrderFilter) (*models.OrderPage, error)) {
+	filter, err := parseOrderFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := find(r.Context(), key, filter)
+	if err != nil {
+		h.writeLookupError(w, field, key, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		h.logger.Error("failed to encode orders", zap.String(field, key), zap.Error(err))
+	}
+}
+
+func (h *Handler) writeLookupError(w http.ResponseWriter, field, key string, err error) {
+	if errors.Is(err, service.ErrOrderNotFound) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Internal error", http.StatusInternalServerError)
+	h.logger.Error("error on looking up orders", zap.String(field, key), zap.Error(err))
+}
+
 func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
-	var order models.Order
-	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+	order, err := decodeOrderBody(r)
+	if err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 	if err := order.Validate(); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeValidationError(w, err)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	key := r.Header.Get("Idempotency-Key")
+	if len(key) > maxIdempotencyKeyLen {
+		http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(changeSource(context.Background(), r), 10*time.Second)
 	defer cancel()
 
-	if err := h.orderService.SaveOrder(ctx, &order); err != nil {
-		if errors.Is(err, service.ErrOrderExists) {
-			http.Error(w, "Order already exists", http.StatusBadRequest)
-		} else {
+	orderUID := order.OrderUID
+	replayed := false
+	if key != "" {
+		sum := sha256.Sum256(order.RawPayload)
+		order, replayed, err = h.orderService.CreateOrderIdempotent(ctx, order, key, hex.EncodeToString(sum[:]))
+	} else {
+		err = h.orderService.SaveOrder(ctx, order)
+	}
+	if err != nil {
+		fields := []zap.Field{zap.String("order_uid", orderUID), zap.String("idempotency_key", key), zap.Error(err)}
+		switch {
+		case errors.Is(err, service.ErrOrderExists):
+			http.Error(w, "Order already exists", http.StatusConflict)
+		case errors.Is(err, service.ErrInvalidOrder):
+			writeValidationError(w, err)
+		case errors.Is(err, service.ErrIdempotencyKeyReused):
+			http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+		case errors.Is(err, service.ErrOrderNotFound):
+			http.Error(w, "Order created with this Idempotency-Key no longer exists", http.StatusConflict)
+		default:
 			http.Error(w, "Internal error", http.StatusInternalServerError)
+			h.logger.Error("error on saving order", fields...)
+			return
 		}
-		h.logger.Error("error on saving order", zap.String("order_uid", order.OrderUID), zap.Error(err))
+		// the client's request was rejected, nothing went wrong here
+		h.logger.Info("order not created", fields...)
 		return
 	}
 
+	if replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
+	setETag(w, order)
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(order); err != nil {
 		h.logger.Error("failed to encode response for saved order", zap.String("order_uid", order.OrderUID), zap.Error(err))
 	}
 }
 
-func readinessHandler(svc *service.OrderService, logger *zap.Logger) http.HandlerFunc {
+func (h *Handler) UpdateOrder(w http.ResponseWriter, r *http.Request) {
+	orderUID := chi.URLParam(r, "uid")
+	expected, err := parseIfMatch(r)
+	if err != nil {
+		http.Error(w, "Invalid If-Match header", http.StatusBadRequest)
+		return
+	}
+
+	order, err := decodeOrderBody(r)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if order.OrderUID == "" {
+		order.OrderUID = orderUID
+	}
+	if order.OrderUID != orderUID {
+		http.Error(w, "order_uid does not match URL", http.StatusBadRequest)
+		return
+	}
+	if err := order.Validate(); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	if err := h.orderService.UpdateOrder(changeSource(r.Context(), r), order, expected); err != nil {
+		h.writeWriteError(w, orderUID, err)
+		return
+	}
+
+	setETag(w, order)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(order); err != nil {
+		h.logger.Error("failed to encode updated order", zap.String("order_uid", orderUID), zap.Error(err))
+	}
+}
+
+func (h *Handler) PatchOrder(w http.ResponseWriter, r *http.Request) {
+	orderUID := chi.URLParam(r, "uid")
+	expected, err := parseIfMatch(r)
+	if err != nil {
+		http.Error(w, "Invalid If-Match header", http.StatusBadRequest)
+		return
+	}
+
+	patch, err := io.ReadAll(io.LimitReader(r.Body, maxOrderBodyBytes))
+	if err != nil || len(patch) == 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	order, err := h.orderService.PatchOrder(changeSource(r.Context(), r), orderUID, patch, expected)
+	if err != nil {
+		h.writeWriteError(w, orderUID, err)
+		return
+	}
+
+	setETag(w, order)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(order); err != nil {
+		h.logger.Error("failed to encode patched order", zap.String("order_uid", orderUID), zap.Error(err))
+	}
+}
+
+func (h *Handler) GetRawPayload(w http.ResponseWriter, r *http.Request) {
+	orderUID := chi.URLParam(r, "uid")
+	raw, err := h.orderService.GetRawPayload(r.Context(), orderUID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrOrderNotFound):
+			http.Error(w, "Order not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrRawNotStored):
+			http.Error(w, "Raw payload not stored for this order", http.StatusNotFound)
+		default:
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(raw); err != nil {
+		h.logger.Error("failed to write raw payload", zap.String("order_uid", orderUID), zap.Error(err))
+	}
+}
+
+func (h *Handler) GetOrderHistory(w http.ResponseWriter, r *http.Request) {
+	orderUID := chi.URLParam(r, "uid")
+	history, err := h.orderService.GetOrderHistory(r.Context(), orderUID)
+	if err != nil {
+		if errors.Is(err, service.ErrOrderNotFound) {
+			http.Error(w, "Order history not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		h.logger.Error("failed to encode order history", zap.String("order_uid", orderUID), zap.Error(err))
+	}
+}
+
+func (h *Handler) DeleteOrder(w http.ResponseWriter, r *http.Request) {
+	orderUID := chi.URLParam(r, "uid")
+	expected, err := parseIfMatch(r)
+	if err != nil {
+		http.Error(w, "Invalid If-Match header", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.orderService.DeleteOrder(changeSource(r.Context(), r), orderUID, expected); err != nil {
+		h.writeWriteError(w, orderUID, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) writeWriteError(w http.ResponseWriter, orderUID string, err error) {
+	switch {
+	case errors.Is(err, service.ErrOrderNotFound):
+		http.Error(w, "Order not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrVersionConflict):
+		http.Error(w, "Order version does not match If-Match", http.StatusPreconditionFailed)
+	case errors.Is(err, service.ErrInvalidOrder):
+		writeValidationError(w, err)
+	case errors.Is(err, service.ErrOrderExists):
+		http.Error(w, "Order conflicts with an existing order", http.StatusConflict)
+	default:
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		h.logger.Error("error on writing order", zap.String("order_uid", orderUID), zap.Error(err))
+	}
+}
+
+func (h *Handler) ListOrders(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseOrderFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.orderService.ListOrders(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		h.logger.Error("error on listing orders", zap.Error(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		h.logger.Error("failed to encode orders page", zap.Error(err))
+	}
+}
+
+func (h *Handler) ListCustomerOrders(w http.ResponseWriter, r *http.Request) {
+	customerID := chi.URLParam(r, "id")
+	filter, err := parseOrderFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.orderService.ListCustomerOrders(r.Context(), customerID, filter)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		h.logger.Error("error on listing customer orders", zap.String("customer_id", customerID), zap.Error(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		h.logger.Error("failed to encode orders page", zap.Error(err))
+	}
+}
+
+func (h *Handler) GetCustomerSummary(w http.ResponseWriter, r *http.Request) {
+	customerID := chi.URLParam(r, "id")
+	summary, err := h.orderService.GetCustomerSummary(r.Context(), customerID)
+	if err != nil {
+		if errors.Is(err, service.ErrCustomerNotFound) {
+			http.Error(w, "Customer not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		h.logger.Error("failed to encode customer summary", zap.String("customer_id", customerID), zap.Error(err))
+	}
+}
+
+// parseOrderFilter reads the listing filters from the query string; the
+// returned error is meant for the client.
+func parseOrderFilter(r *http.Request) (models.OrderFilter, error) {
+	q := r.URL.Query()
+	filter := models.OrderFilter{
+		CustomerID:      q.Get("customer_id"),
+		TrackNumber:     q.Get("track_number"),
+		DeliveryService: q.Get("delivery_service"),
+		Brand:           q.Get("brand"),
+	}
+
+	var err error
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 {
+			return filter, errors.New("Invalid limit")
+		}
+	}
+	if v := q.Get("date_from"); v != "" {
+		if filter.CreatedFrom, err = parseDateParam(v); err != nil {
+			return filter, errors.New("Invalid date_from")
+		}
+	}
+	if v := q.Get("date_to"); v != "" {
+		if filter.CreatedTo, err = parseDateEndParam(v); err != nil {
+			return filter, errors.New("Invalid date_to")
+		}
+	}
+	if v := q.Get("cursor"); v != "" {
+		if filter.After, err = models.DecodeOrderCursor(v); err != nil {
+			return filter, errors.New("Invalid cursor")
+		}
+	}
+	return filter, nil
+}
+
+// decodeOrderBody keeps the request body as the order's raw payload.
+func decodeOrderBody(r *http.Request) (*models.Order, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxOrderBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	var order models.Order
+	if err := json.Unmarshal(body, &order); err != nil {
+		return nil, err
+	}
+	order.RawPayload = body
+	return &order, nil
+}
+
+// changeSource tags ctx with the request ID for the order history.
+func changeSource(ctx context.Context, r *http.Request) context.Context {
+	return models.WithChangeSource(ctx, models.ChangeSource{
+		Kind: models.SourceHTTP,
+		Ref:  middleware.GetReqID(r.Context()),
+	})
+}
+
+func setETag(w http.ResponseWriter, order *models.Order) {
+	if order.Version > 0 {
+		w.Header().Set("ETag", `"`+strconv.Itoa(order.Version)+`"`)
+	}
+}
+
+// parseIfMatch returns the version required by If-Match, or 0 when the
+// header is absent or "*".
+func parseIfMatch(r *http.Request) (int, error) {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	if v == "" || v == "*" {
+		return 0, nil
+	}
+	v = strings.TrimPrefix(v, "W/")
+	if len(v) < 2 || v[0] != '"' || v[len(v)-1] != '"' {
+		return 0, errors.New("malformed entity tag")
+	}
+	version, err := strconv.Atoi(v[1 : len(v)-1])
+	if err != nil || version <= 0 {
+		return 0, errors.New("malformed entity tag")
+	}
+	return version, nil
+}
+
+func (h *Handler) SearchOrders(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := h.orderService.SearchOrders(r.Context(), q.Get("q"), limit)
+	if err != nil {
+		if errors.Is(err, service.ErrQueryTooShort) {
+			http.Error(w, "Query must be at least "+strconv.Itoa(service.MinSearchQueryLen)+" characters", http.StatusBadRequest)
+		} else {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		h.logger.Error("failed to encode search results", zap.Error(err))
+	}
+}
+
+func parseDateParam(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, v)
+}
+
+// parseDateEndParam parses an exclusive upper bound. A date without a time
+// includes that whole day, so the bound is the start of the next one.
+func parseDateEndParam(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return t, err
+	}
+	return t.AddDate(0, 0, 1), nil
+}
+
+func readinessHandler(registry *health.Registry, logger *zap.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
 		defer cancel()
-		if _, err := svc.GetAllOrders(ctx); err != nil {
-			logger.Warn("readiness check failed", zap.Error(err))
-			w.WriteHeader(http.StatusServiceUnavailable)
-			return
+
+		report := registry.Check(ctx)
+		status := http.StatusOK
+		if report.Status == health.StatusDown {
+			logger.Warn("readiness check failed", zap.Any("components", report.Components))
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			logger.Error("failed to encode readiness report", zap.Error(err))
+		}
+	}
+}
+
+func warmupHandler(warmer *warmup.Warmer, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(warmer.Progress()); err != nil {
+			logger.Error("failed to encode warm-up progress", zap.Error(err))
 		}
-		w.WriteHeader(http.StatusOK)
 	}
 }
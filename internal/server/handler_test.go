@@ -3,16 +3,25 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 
+	"wb-tech-1task/internal/health"
+	"wb-tech-1task/internal/metrics"
 	"wb-tech-1task/internal/models"
 	"wb-tech-1task/internal/service"
 	"wb-tech-1task/internal/service/mocks"
@@ -81,8 +90,6 @@ func TestHandler_GetOrder(t *testing.T) {
 		},
 	}
 
-	ctx := context.Background()
-
 	t.Run("success", func(t *testing.T) {
 		mockCache.EXPECT().Get("test123").Return(testOrder, true, nil)
 
@@ -99,7 +106,7 @@ func TestHandler_GetOrder(t *testing.T) {
 
 	t.Run("not found", func(t *testing.T) {
 		mockCache.EXPECT().Get("notfound").Return(nil, false, nil)
-		mockRepo.EXPECT().GetOrder(ctx, "notfound").Return(nil, service.ErrOrderNotFound)
+		mockRepo.EXPECT().GetOrder(gomock.Any(), "notfound").Return(nil, service.ErrOrderNotFound)
 
 		req := httptest.NewRequest("GET", "/order?uid=notfound", nil)
 		w := httptest.NewRecorder()
@@ -173,6 +180,7 @@ func TestHandler_CreateOrder(t *testing.T) {
 		},
 	}
 	orderBody, _ := json.Marshal(testOrder)
+	testOrder.RawPayload = orderBody
 
 	t.Run("success", func(t *testing.T) {
 		mockRepo.EXPECT().SaveOrder(gomock.Any(), &testOrder).Return(nil)
@@ -196,4 +204,450 @@ func TestHandler_CreateOrder(t *testing.T) {
 
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
+
+	t.Run("invalid order answers with problem details", func(t *testing.T) {
+		invalid := testOrder
+		invalid.TrackNumber = ""
+		invalid.Items = []models.Item{testOrder.Items[0], testOrder.Items[0]}
+		invalid.Items[1].ChrtID++
+		invalid.Items[1].Price = 0
+		body, _ := json.Marshal(invalid)
+
+		req := httptest.NewRequest("POST", "/order", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+
+		handler.CreateOrder(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+		var p problem
+		json.NewDecoder(w.Body).Decode(&p)
+		assert.Equal(t, http.StatusBadRequest, p.Status)
+		assert.Equal(t, []models.Violation{
+			{Path: "track_number", Rule: models.RuleRequired, Message: "is required"},
+			{Path: "items[1].price", Rule: models.RulePositive, Message: "must be positive"},
+		}, p.Violations)
+	})
+}
+
+func TestHandler_CreateOrderLogLevels(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := mocks.NewMockOrderCache(ctrl)
+	mockRepo := mocks.NewMockOrderRepository(ctrl)
+	core, logs := observer.New(zap.InfoLevel)
+	handler := NewHandler(service.NewOrderService(mockCache, mockRepo, zap.NewNop()), zap.New(core))
+
+	body, _ := json.Marshal(validTestOrder("test123"))
+	create := func() int {
+		w := httptest.NewRecorder()
+		handler.CreateOrder(w, httptest.NewRequest("POST", "/order", bytes.NewReader(body)))
+		return w.Code
+	}
+
+	mockRepo.EXPECT().SaveOrder(gomock.Any(), gomock.Any()).Return(&pq.Error{Code: "23505"})
+	assert.Equal(t, http.StatusConflict, create())
+	entries := logs.TakeAll()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, zap.InfoLevel, entries[0].Level)
+
+	mockRepo.EXPECT().SaveOrder(gomock.Any(), gomock.Any()).Return(errors.New("db down"))
+	assert.Equal(t, http.StatusInternalServerError, create())
+	entries = logs.TakeAll()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, zap.ErrorLevel, entries[0].Level)
+}
+
+func TestHandler_ListOrders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := mocks.NewMockOrderCache(ctrl)
+	mockRepo := mocks.NewMockOrderRepository(ctrl)
+	logger := zap.NewNop()
+
+	realService := service.NewOrderService(mockCache, mockRepo, logger)
+	handler := NewHandler(realService, logger)
+
+	fixedTime := time.Date(2022, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("passes filters to repository", func(t *testing.T) {
+		mockRepo.EXPECT().ListOrders(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, filter models.OrderFilter) ([]*models.Order, error) {
+				assert.Equal(t, "test_customer", filter.CustomerID)
+				assert.Equal(t, "Vivienne Sabo", filter.Brand)
+				assert.Equal(t, fixedTime, filter.CreatedFrom)
+				assert.Equal(t, 11, filter.Limit)
+				return []*models.Order{{OrderUID: "test123", DateCreated: fixedTime}}, nil
+			})
+
+		req := httptest.NewRequest("GET", "/orders?customer_id=test_customer&brand=Vivienne+Sabo&date_from=2022-01-01T12:00:00Z&limit=10", nil)
+		w := httptest.NewRecorder()
+
+		handler.ListOrders(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var page models.OrderPage
+		json.NewDecoder(w.Body).Decode(&page)
+		assert.Len(t, page.Orders, 1)
+		assert.Empty(t, page.NextCursor)
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/orders?cursor=garbage", nil)
+		w := httptest.NewRecorder()
+
+		handler.ListOrders(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("date-only date_to includes the whole day", func(t *testing.T) {
+		for query, want := range map[string]time.Time{
+			"date_to=2024-01-31":           time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+			"date_to=2024-01-31T10:00:00Z": time.Date(2024, time.January, 31, 10, 0, 0, 0, time.UTC),
+		} {
+			mockRepo.EXPECT().ListOrders(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, filter models.OrderFilter) ([]*models.Order, error) {
+					assert.Equal(t, want, filter.CreatedTo, query)
+					return nil, nil
+				})
+
+			w := httptest.NewRecorder()
+			handler.ListOrders(w, httptest.NewRequest("GET", "/orders?"+query, nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+	})
+}
+
+func TestReadinessHandler(t *testing.T) {
+	logger := zap.NewNop()
+
+	t.Run("ready when critical components are up", func(t *testing.T) {
+		registry := health.NewRegistry(time.Second)
+		registry.Register("postgres", health.CheckerFunc(func(ctx context.Context) error { return nil }), true)
+		registry.Register("kafka", health.CheckerFunc(func(ctx context.Context) error { return errors.New("dial failed") }), false)
+
+		w := httptest.NewRecorder()
+		readinessHandler(registry, logger)(w, httptest.NewRequest("GET", "/ready", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var report health.Report
+		json.NewDecoder(w.Body).Decode(&report)
+		assert.Equal(t, health.StatusDegraded, report.Status)
+		assert.Equal(t, "dial failed", report.Components["kafka"].Error)
+	})
+
+	t.Run("not ready when a critical component is down", func(t *testing.T) {
+		registry := health.NewRegistry(time.Second)
+		registry.Register("postgres", health.CheckerFunc(func(ctx context.Context) error { return errors.New("connection refused") }), true)
+
+		w := httptest.NewRecorder()
+		readinessHandler(registry, logger)(w, httptest.NewRequest("GET", "/ready", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
+
+func TestMetricsMiddleware_LabelsByRoutePattern(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Metrics())
+	r.Get("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	before := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("GET", "/items/{id}", "418"))
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items/1", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items/2", nil))
+
+	after := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("GET", "/items/{id}", "418"))
+	assert.Equal(t, float64(2), after-before)
+}
+
+func validTestOrder(uid string) models.Order {
+	return models.Order{
+		OrderUID:        uid,
+		TrackNumber:     "TRACK123",
+		Entry:           "WBIL",
+		Locale:          "en",
+		CustomerID:      "test_customer",
+		DeliveryService: "meest",
+		DateCreated:     time.Date(2022, time.January, 1, 12, 0, 0, 0, time.UTC),
+		Delivery: models.Delivery{
+			Name: "Test Testov", Phone: "+9720000000", Zip: "2639809", City: "Kiryat Mozkin",
+			Address: "Ploshad Mira 15", Region: "Kraiot", Email: "test@gmail.com",
+		},
+		Payment: models.Payment{Transaction: uid, Currency: "USD", Provider: "wbpay", Bank: "alpha", Amount: 1817},
+		Items: []models.Item{{
+			ChrtID: 9934930, TrackNumber: "TRACK123", Price: 453, Rid: "rid", Name: "Mascaras",
+			Size: "0", TotalPrice: 317, NmID: 2389212, Brand: "Vivienne Sabo", Status: 202,
+		}},
+	}
+}
+
+func TestHandler_UpdateAndDeleteOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := mocks.NewMockOrderCache(ctrl)
+	mockRepo := mocks.NewMockOrderRepository(ctrl)
+	handler := NewHandler(service.NewOrderService(mockCache, mockRepo, zap.NewNop()), zap.NewNop())
+
+	r := chi.NewRouter()
+	r.Put("/order/{uid}", handler.UpdateOrder)
+	r.Patch("/order/{uid}", handler.PatchOrder)
+	r.Delete("/order/{uid}", handler.DeleteOrder)
+	r.Get("/order/{uid}/raw", handler.GetRawPayload)
+
+	order := validTestOrder("test123")
+	body, _ := json.Marshal(order)
+
+	t.Run("put with matching If-Match", func(t *testing.T) {
+		mockRepo.EXPECT().UpdateOrder(gomock.Any(), gomock.Any(), 2).
+			DoAndReturn(func(_ context.Context, o *models.Order, _ int) error {
+				o.Version = 3
+				return nil
+			})
+		mockCache.EXPECT().Set(gomock.Any()).Return(nil)
+
+		req := httptest.NewRequest(http.MethodPut, "/order/test123", bytes.NewReader(body))
+		req.Header.Set("If-Match", `"2"`)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+	})
+
+	t.Run("put with stale If-Match", func(t *testing.T) {
+		mockRepo.EXPECT().UpdateOrder(gomock.Any(), gomock.Any(), 1).Return(service.ErrVersionConflict)
+
+		req := httptest.NewRequest(http.MethodPut, "/order/test123", bytes.NewReader(body))
+		req.Header.Set("If-Match", `"1"`)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	})
+
+	t.Run("put with mismatched uid", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/order/other", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("malformed If-Match", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/order/test123", nil)
+		req.Header.Set("If-Match", "2")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("patch missing order", func(t *testing.T) {
+		mockRepo.EXPECT().GetOrder(gomock.Any(), "missing").Return(nil, service.ErrOrderNotFound)
+
+		req := httptest.NewRequest(http.MethodPatch, "/order/missing", bytes.NewReader([]byte(`{"entry":"X"}`)))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("raw payload", func(t *testing.T) {
+		mockRepo.EXPECT().GetRawPayload(gomock.Any(), "test123").Return([]byte(`{"order_uid":"test123","extra":1}`), nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/order/test123/raw", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"order_uid":"test123","extra":1}`, w.Body.String())
+	})
+
+	t.Run("raw payload not stored", func(t *testing.T) {
+		mockRepo.EXPECT().GetRawPayload(gomock.Any(), "old").Return(nil, service.ErrRawNotStored)
+
+		req := httptest.NewRequest(http.MethodGet, "/order/old/raw", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("delete any version", func(t *testing.T) {
+		mockRepo.EXPECT().DeleteOrder(gomock.Any(), "test123", 0).Return(nil)
+		mockCache.EXPECT().Delete("test123")
+
+		req := httptest.NewRequest(http.MethodDelete, "/order/test123", nil)
+		req.Header.Set("If-Match", "*")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+}
+
+func TestHandler_CreateOrderIdempotencyKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := mocks.NewMockOrderCache(ctrl)
+	mockRepo := mocks.NewMockOrderRepository(ctrl)
+	handler := NewHandler(service.NewOrderService(mockCache, mockRepo, zap.NewNop()), zap.NewNop())
+
+	order := validTestOrder("test123")
+	order.Version = 1
+	body, _ := json.Marshal(order)
+
+	t.Run("repeated key replays stored order", func(t *testing.T) {
+		sum := sha256.Sum256(body)
+		mockRepo.EXPECT().SaveOrder(gomock.Any(), gomock.Any()).Return(service.ErrAlreadyProcessed)
+		mockRepo.EXPECT().GetProcessedMessage(gomock.Any(), "http:abc").
+			Return(&models.ProcessedMessage{OrderUID: "test123", RequestHash: hex.EncodeToString(sum[:])}, nil)
+		mockCache.EXPECT().Get("test123").Return(&order, true, nil)
+
+		req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewReader(body))
+		req.Header.Set("Idempotency-Key", "abc")
+		w := httptest.NewRecorder()
+		handler.CreateOrder(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	})
+
+	t.Run("key reused with different body", func(t *testing.T) {
+		mockRepo.EXPECT().SaveOrder(gomock.Any(), gomock.Any()).Return(service.ErrAlreadyProcessed)
+		mockRepo.EXPECT().GetProcessedMessage(gomock.Any(), "http:abc").
+			Return(&models.ProcessedMessage{OrderUID: "test123", RequestHash: "other"}, nil)
+
+		req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewReader(body))
+		req.Header.Set("Idempotency-Key", "abc")
+		w := httptest.NewRecorder()
+		handler.CreateOrder(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+}
+
+func TestHandler_LookupOrders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := mocks.NewMockOrderCache(ctrl)
+	mockRepo := mocks.NewMockOrderRepository(ctrl)
+	handler := NewHandler(service.NewOrderService(mockCache, mockRepo, zap.NewNop()), zap.NewNop())
+
+	r := chi.NewRouter()
+	r.Get("/orders/by-track/{track}", handler.GetOrdersByTrack)
+	r.Get("/orders/by-transaction/{tx}", handler.GetOrderByTransaction)
+	r.Get("/orders/by-rid/{rid}", handler.GetOrdersByRid)
+
+	order := validTestOrder("test123")
+	order.Version = 4
+
+	t.Run("transaction from cache", func(t *testing.T) {
+		mockCache.EXPECT().FindByTransaction("test123").Return([]*models.Order{&order})
+
+		req := httptest.NewRequest(http.MethodGet, "/orders/by-transaction/test123", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+		var got models.Order
+		json.NewDecoder(w.Body).Decode(&got)
+		assert.Equal(t, "test123", got.OrderUID)
+	})
+
+	t.Run("track from db", func(t *testing.T) {
+		mockCache.EXPECT().FindByTrack("TRACK1").Return(nil, false)
+		mockCache.EXPECT().LookupGen().Return(uint64(7))
+		mockRepo.EXPECT().ListOrders(gomock.Any(), models.OrderFilter{TrackNumber: "TRACK1", Limit: service.DefaultListLimit + 1}).
+			Return([]*models.Order{&order}, nil)
+		mockCache.EXPECT().SetTrack("TRACK1", []*models.Order{&order}, uint64(7))
+
+		req := httptest.NewRequest(http.MethodGet, "/orders/by-track/TRACK1", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var page models.OrderPage
+		json.NewDecoder(w.Body).Decode(&page)
+		assert.Len(t, page.Orders, 1)
+	})
+
+	t.Run("unknown rid", func(t *testing.T) {
+		mockCache.EXPECT().FindByRid("nope").Return(nil, false)
+		mockCache.EXPECT().LookupGen().Return(uint64(7))
+		mockRepo.EXPECT().ListOrders(gomock.Any(), gomock.Any()).Return(nil, nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/orders/by-rid/nope", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestHandler_Customer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := mocks.NewMockOrderCache(ctrl)
+	mockRepo := mocks.NewMockOrderRepository(ctrl)
+	handler := NewHandler(service.NewOrderService(mockCache, mockRepo, zap.NewNop()), zap.NewNop())
+
+	r := chi.NewRouter()
+	r.Get("/customers/{id}/orders", handler.ListCustomerOrders)
+	r.Get("/customers/{id}/summary", handler.GetCustomerSummary)
+
+	t.Run("orders are filtered by customer", func(t *testing.T) {
+		mockRepo.EXPECT().ListOrders(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, filter models.OrderFilter) ([]*models.Order, error) {
+				assert.Equal(t, "cust1", filter.CustomerID)
+				assert.Equal(t, 6, filter.Limit)
+				return []*models.Order{{OrderUID: "a"}}, nil
+			})
+
+		req := httptest.NewRequest(http.MethodGet, "/customers/cust1/orders?customer_id=other&limit=5", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("summary", func(t *testing.T) {
+		mockRepo.EXPECT().GetCustomerSummary(gomock.Any(), "cust1").Return(&models.CustomerSummary{
+			CustomerID: "cust1",
+			OrderCount: 2,
+			TotalSpent: map[string]int{"USD": 1817},
+		}, nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/customers/cust1/summary", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var got models.CustomerSummary
+		json.NewDecoder(w.Body).Decode(&got)
+		assert.Equal(t, 2, got.OrderCount)
+		assert.Equal(t, 1817, got.TotalSpent["USD"])
+	})
+
+	t.Run("unknown customer", func(t *testing.T) {
+		mockRepo.EXPECT().GetCustomerSummary(gomock.Any(), "nobody").Return(&models.CustomerSummary{CustomerID: "nobody"}, nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/customers/nobody/summary", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
 }
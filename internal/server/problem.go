@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"wb-tech-1task/internal/models"
+)
+
+const (
+	problemContentType  = "application/problem+json"
+	problemInvalidOrder = "/problems/invalid-order"
+)
+
+// problem is an RFC 7807 problem details body.
+type problem struct {
+	Type       string             `json:"type"`
+	Title      string             `json:"title"`
+	Status     int                `json:"status"`
+	Detail     string             `json:"detail,omitempty"`
+	Violations []models.Violation `json:"violations,omitempty"`
+}
+
+func writeProblem(w http.ResponseWriter, p problem) {
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// writeValidationError answers with a problem listing every violation when
+// err carries a *models.ValidationError, and with plain text otherwise.
+func writeValidationError(w http.ResponseWriter, err error) {
+	var verr *models.ValidationError
+	if !errors.As(err, &verr) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeProblem(w, problem{
+		Type:       problemInvalidOrder,
+		Title:      "Order is invalid",
+		Status:     http.StatusBadRequest,
+		Detail:     verr.Error(),
+		Violations: verr.Violations,
+	})
+}
@@ -8,38 +8,99 @@ import (
 	"os"
 	"time"
 
+	"wb-tech-1task/internal/health"
+	"wb-tech-1task/internal/kafka"
+	"wb-tech-1task/internal/metrics"
 	"wb-tech-1task/internal/service"
+	"wb-tech-1task/internal/warmup"
 )
 
-func NewRouter(svc *service.OrderService, logger *zap.Logger) http.Handler {
+type RouterOptions struct {
+	Health      *health.Registry
+	Warmup      *warmup.Warmer
+	DeadLetters *kafka.DeadLetterReplayer
+	Stats       *service.StatsService
+	AdminToken  string
+}
+
+func NewRouter(svc *service.OrderService, opts RouterOptions, logger *zap.Logger) http.Handler {
+	if opts.Health == nil {
+		opts.Health = health.NewRegistry(0)
+	}
+
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
 	r.Use(ZapLogger(logger))
+	r.Use(Metrics())
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(15 * time.Second))
-
-	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
-	r.Get("/ready", readinessHandler(svc, logger))
 
 	h := NewHandler(svc, logger)
-	r.Get("/order", h.GetOrder)
-	r.Post("/order", h.CreateOrder)
+	// exports, imports and DLQ scans run for as long as they take, so they
+	// are not subject to the request timeout
+	r.Get("/orders/export", h.ExportOrders)
+	r.Post("/orders/batch", h.ImportOrders)
+	if opts.DeadLetters != nil {
+		a := NewAdminHandler(opts.DeadLetters, logger)
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(adminAuth(opts.AdminToken))
+			r.Get("/dlq", a.ListDeadLetters)
+			r.Get("/dlq/{partition}/{offset}", a.GetDeadLetter)
+			r.Post("/dlq/replay", a.ReplayDeadLetters)
+		})
+	}
 
-	var fs http.Handler
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(15 * time.Second))
 
-	if _, err := os.Stat("web/static"); err == nil {
-		fs = http.FileServer(http.Dir("web/static"))
-	} else if _, err := os.Stat("/web/static"); err == nil {
-		fs = http.FileServer(http.Dir("/web/static"))
-	} else {
-		fs = http.NotFoundHandler()
-	}
+		r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		r.Get("/ready", readinessHandler(opts.Health, logger))
+		r.Handle("/metrics", metrics.Handler())
+		if opts.Warmup != nil {
+			r.Get("/cache/warmup", warmupHandler(opts.Warmup, logger))
+		}
+
+		r.Get("/order", h.GetOrder)
+		r.Post("/order", h.CreateOrder)
+		r.Get("/order/{uid}", h.GetOrder)
+		r.Put("/order/{uid}", h.UpdateOrder)
+		r.Patch("/order/{uid}", h.PatchOrder)
+		r.Delete("/order/{uid}", h.DeleteOrder)
+		r.Get("/order/{uid}/raw", h.GetRawPayload)
+		r.Get("/order/{uid}/history", h.GetOrderHistory)
+		r.Get("/orders", h.ListOrders)
+		r.Get("/orders/search", h.SearchOrders)
+		r.Get("/orders/by-track/{track}", h.GetOrdersByTrack)
+		r.Get("/orders/by-transaction/{tx}", h.GetOrderByTransaction)
+		r.Get("/orders/by-rid/{rid}", h.GetOrdersByRid)
+		r.Get("/customers/{id}/orders", h.ListCustomerOrders)
+		r.Get("/customers/{id}/summary", h.GetCustomerSummary)
+
+		if opts.Stats != nil {
+			st := NewStatsHandler(opts.Stats, logger)
+			r.Route("/stats", func(r chi.Router) {
+				r.Get("/orders", st.OrderVolume)
+				r.Get("/brands", st.TopBrands)
+				r.Get("/products", st.TopProducts)
+				r.Get("/delivery-services", st.DeliveryShares)
+			})
+		}
 
-	r.Handle("/*", fs)
+		var fs http.Handler
+
+		if _, err := os.Stat("web/static"); err == nil {
+			fs = http.FileServer(http.Dir("web/static"))
+		} else if _, err := os.Stat("/web/static"); err == nil {
+			fs = http.FileServer(http.Dir("/web/static"))
+		} else {
+			fs = http.NotFoundHandler()
+		}
+
+		r.Handle("/*", fs)
+	})
 
 	return r
 }
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"wb-tech-1task/internal/models"
+	"wb-tech-1task/internal/service"
+)
+
+type StatsHandler struct {
+	stats  *service.StatsService
+	logger *zap.Logger
+}
+
+func NewStatsHandler(stats *service.StatsService, logger *zap.Logger) *StatsHandler {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &StatsHandler{
+		stats:  stats,
+		logger: logger,
+	}
+}
+
+func (h *StatsHandler) OrderVolume(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseStatsFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	volume, err := h.stats.OrderVolume(r.Context(), filter)
+	h.write(w, volume, err)
+}
+
+func (h *StatsHandler) TopBrands(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseStatsFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sales, err := h.stats.TopBrands(r.Context(), filter)
+	h.write(w, sales, err)
+}
+
+func (h *StatsHandler) TopProducts(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseStatsFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sales, err := h.stats.TopProducts(r.Context(), filter)
+	h.write(w, sales, err)
+}
+
+func (h *StatsHandler) DeliveryShares(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseStatsFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	shares, err := h.stats.DeliveryShares(r.Context(), filter)
+	h.write(w, shares, err)
+}
+
+func (h *StatsHandler) write(w http.ResponseWriter, v any, err error) {
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidStatsFilter) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, v, h.logger)
+}
+
+func parseStatsFilter(r *http.Request) (models.StatsFilter, error) {
+	q := r.URL.Query()
+	filter := models.StatsFilter{
+		Bucket:   q.Get("bucket"),
+		Currency: q.Get("currency"),
+	}
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		if filter.From, err = parseDateParam(v); err != nil {
+			return filter, errors.New("Invalid from")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if filter.To, err = parseDateEndParam(v); err != nil {
+			return filter, errors.New("Invalid to")
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 {
+			return filter, errors.New("Invalid limit")
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"wb-tech-1task/internal/models"
+	"wb-tech-1task/internal/service"
+)
+
+type fakeStatsRepo struct {
+	last models.StatsFilter
+}
+
+func (r *fakeStatsRepo) OrderVolume(ctx context.Context, filter models.StatsFilter) ([]models.OrderVolume, error) {
+	r.last = filter
+	return []models.OrderVolume{{Bucket: filter.From, Currency: "USD", Orders: 2, Amount: 300}}, nil
+}
+func (r *fakeStatsRepo) TopBrands(ctx context.Context, filter models.StatsFilter) ([]models.Sales, error) {
+	r.last = filter
+	return []models.Sales{{Brand: "Vivienne Sabo", Items: 3, Revenue: map[string]int64{"RUB": 900}}}, nil
+}
+func (r *fakeStatsRepo) TopProducts(ctx context.Context, filter models.StatsFilter) ([]models.Sales, error) {
+	r.last = filter
+	return nil, nil
+}
+func (r *fakeStatsRepo) DeliveryShares(ctx context.Context, filter models.StatsFilter) ([]models.DeliveryShare, error) {
+	r.last = filter
+	return nil, nil
+}
+
+func TestStats_Endpoints(t *testing.T) {
+	repo := &fakeStatsRepo{}
+	router := NewRouter(nil, RouterOptions{
+		Stats: service.NewStatsService(repo, time.Minute, nil),
+	}, zap.NewNop())
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/stats/orders?bucket=week&from=2024-01-01&to=2024-02-01&currency=USD", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, models.BucketWeek, repo.last.Bucket)
+	assert.Equal(t, "USD", repo.last.Currency)
+	assert.Equal(t, time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC), repo.last.To)
+	var volume []models.OrderVolume
+	json.NewDecoder(w.Body).Decode(&volume)
+	assert.Len(t, volume, 1)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/stats/brands?limit=5", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 5, repo.last.Limit)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/stats/delivery-services?currency=RUB", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "RUB", repo.last.Currency)
+	assert.JSONEq(t, `[]`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/stats/orders?bucket=month", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestStats_ToBound(t *testing.T) {
+	repo := &fakeStatsRepo{}
+	router := NewRouter(nil, RouterOptions{
+		Stats: service.NewStatsService(repo, 0, nil),
+	}, zap.NewNop())
+
+	t.Run("date only includes the whole day", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/stats/delivery-services?from=2024-03-01&to=2024-03-01", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), repo.last.From)
+		assert.Equal(t, time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), repo.last.To)
+	})
+
+	t.Run("timestamp is exclusive", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/stats/delivery-services?to=2024-03-01T00:00:00Z", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), repo.last.To)
+	})
+}
@@ -27,6 +27,21 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Flush lets streaming handlers push what they wrote so far through the
+// middleware wrapping.
+func (rw *responseWriter) Flush() {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func ZapLogger(logger *zap.Logger) func(next http.Handler) http.Handler {
 	if logger == nil {
 		logger = zap.NewNop()
@@ -81,6 +81,50 @@ func (mr *MockOrderCacheMockRecorder) Delete(orderUID any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockOrderCache)(nil).Delete), orderUID)
 }
 
+// FindByRid mocks base method.
+func (m *MockOrderCache) FindByRid(rid string) ([]*models.Order, bool) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindByRid", rid)
+	ret0, _ := ret[0].([]*models.Order)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// FindByRid indicates an expected call of FindByRid.
+func (mr *MockOrderCacheMockRecorder) FindByRid(rid any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindByRid", reflect.TypeOf((*MockOrderCache)(nil).FindByRid), rid)
+}
+
+// FindByTrack mocks base method.
+func (m *MockOrderCache) FindByTrack(trackNumber string) ([]*models.Order, bool) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindByTrack", trackNumber)
+	ret0, _ := ret[0].([]*models.Order)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// FindByTrack indicates an expected call of FindByTrack.
+func (mr *MockOrderCacheMockRecorder) FindByTrack(trackNumber any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindByTrack", reflect.TypeOf((*MockOrderCache)(nil).FindByTrack), trackNumber)
+}
+
+// FindByTransaction mocks base method.
+func (m *MockOrderCache) FindByTransaction(transaction string) []*models.Order {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindByTransaction", transaction)
+	ret0, _ := ret[0].([]*models.Order)
+	return ret0
+}
+
+// FindByTransaction indicates an expected call of FindByTransaction.
+func (mr *MockOrderCacheMockRecorder) FindByTransaction(transaction any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindByTransaction", reflect.TypeOf((*MockOrderCache)(nil).FindByTransaction), transaction)
+}
+
 // Get mocks base method.
 func (m *MockOrderCache) Get(orderUID string) (*models.Order, bool, error) {
 	m.ctrl.T.Helper()
@@ -112,6 +156,20 @@ func (mr *MockOrderCacheMockRecorder) GetAll() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockOrderCache)(nil).GetAll))
 }
 
+// LookupGen mocks base method.
+func (m *MockOrderCache) LookupGen() uint64 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LookupGen")
+	ret0, _ := ret[0].(uint64)
+	return ret0
+}
+
+// LookupGen indicates an expected call of LookupGen.
+func (mr *MockOrderCacheMockRecorder) LookupGen() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupGen", reflect.TypeOf((*MockOrderCache)(nil).LookupGen))
+}
+
 // Set mocks base method.
 func (m *MockOrderCache) Set(order *models.Order) error {
 	m.ctrl.T.Helper()
@@ -126,6 +184,30 @@ func (mr *MockOrderCacheMockRecorder) Set(order any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Set", reflect.TypeOf((*MockOrderCache)(nil).Set), order)
 }
 
+// SetRid mocks base method.
+func (m *MockOrderCache) SetRid(rid string, orders []*models.Order, gen uint64) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetRid", rid, orders, gen)
+}
+
+// SetRid indicates an expected call of SetRid.
+func (mr *MockOrderCacheMockRecorder) SetRid(rid, orders, gen any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetRid", reflect.TypeOf((*MockOrderCache)(nil).SetRid), rid, orders, gen)
+}
+
+// SetTrack mocks base method.
+func (m *MockOrderCache) SetTrack(trackNumber string, orders []*models.Order, gen uint64) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetTrack", trackNumber, orders, gen)
+}
+
+// SetTrack indicates an expected call of SetTrack.
+func (mr *MockOrderCacheMockRecorder) SetTrack(trackNumber, orders, gen any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTrack", reflect.TypeOf((*MockOrderCache)(nil).SetTrack), trackNumber, orders, gen)
+}
+
 // MockOrderRepository is a mock of OrderRepository interface.
 type MockOrderRepository struct {
 	ctrl     *gomock.Controller
@@ -164,6 +246,20 @@ func (mr *MockOrderRepositoryMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockOrderRepository)(nil).Close))
 }
 
+// DeleteOrder mocks base method.
+func (m *MockOrderRepository) DeleteOrder(ctx context.Context, orderUID string, expectedVersion int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteOrder", ctx, orderUID, expectedVersion)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteOrder indicates an expected call of DeleteOrder.
+func (mr *MockOrderRepositoryMockRecorder) DeleteOrder(ctx, orderUID, expectedVersion any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteOrder", reflect.TypeOf((*MockOrderRepository)(nil).DeleteOrder), ctx, orderUID, expectedVersion)
+}
+
 // GetAllOrders mocks base method.
 func (m *MockOrderRepository) GetAllOrders(ctx context.Context) ([]*models.Order, error) {
 	m.ctrl.T.Helper()
@@ -179,6 +275,21 @@ func (mr *MockOrderRepositoryMockRecorder) GetAllOrders(ctx any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllOrders", reflect.TypeOf((*MockOrderRepository)(nil).GetAllOrders), ctx)
 }
 
+// GetCustomerSummary mocks base method.
+func (m *MockOrderRepository) GetCustomerSummary(ctx context.Context, customerID string) (*models.CustomerSummary, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCustomerSummary", ctx, customerID)
+	ret0, _ := ret[0].(*models.CustomerSummary)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCustomerSummary indicates an expected call of GetCustomerSummary.
+func (mr *MockOrderRepositoryMockRecorder) GetCustomerSummary(ctx, customerID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCustomerSummary", reflect.TypeOf((*MockOrderRepository)(nil).GetCustomerSummary), ctx, customerID)
+}
+
 // GetOrder mocks base method.
 func (m *MockOrderRepository) GetOrder(ctx context.Context, orderUID string) (*models.Order, error) {
 	m.ctrl.T.Helper()
@@ -194,6 +305,66 @@ func (mr *MockOrderRepositoryMockRecorder) GetOrder(ctx, orderUID any) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrder", reflect.TypeOf((*MockOrderRepository)(nil).GetOrder), ctx, orderUID)
 }
 
+// GetOrderHistory mocks base method.
+func (m *MockOrderRepository) GetOrderHistory(ctx context.Context, orderUID string) ([]models.OrderRevision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrderHistory", ctx, orderUID)
+	ret0, _ := ret[0].([]models.OrderRevision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrderHistory indicates an expected call of GetOrderHistory.
+func (mr *MockOrderRepositoryMockRecorder) GetOrderHistory(ctx, orderUID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderHistory", reflect.TypeOf((*MockOrderRepository)(nil).GetOrderHistory), ctx, orderUID)
+}
+
+// GetProcessedMessage mocks base method.
+func (m *MockOrderRepository) GetProcessedMessage(ctx context.Context, key string) (*models.ProcessedMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetProcessedMessage", ctx, key)
+	ret0, _ := ret[0].(*models.ProcessedMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetProcessedMessage indicates an expected call of GetProcessedMessage.
+func (mr *MockOrderRepositoryMockRecorder) GetProcessedMessage(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetProcessedMessage", reflect.TypeOf((*MockOrderRepository)(nil).GetProcessedMessage), ctx, key)
+}
+
+// GetRawPayload mocks base method.
+func (m *MockOrderRepository) GetRawPayload(ctx context.Context, orderUID string) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRawPayload", ctx, orderUID)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRawPayload indicates an expected call of GetRawPayload.
+func (mr *MockOrderRepositoryMockRecorder) GetRawPayload(ctx, orderUID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRawPayload", reflect.TypeOf((*MockOrderRepository)(nil).GetRawPayload), ctx, orderUID)
+}
+
+// ListOrders mocks base method.
+func (m *MockOrderRepository) ListOrders(ctx context.Context, filter models.OrderFilter) ([]*models.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListOrders", ctx, filter)
+	ret0, _ := ret[0].([]*models.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListOrders indicates an expected call of ListOrders.
+func (mr *MockOrderRepositoryMockRecorder) ListOrders(ctx, filter any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListOrders", reflect.TypeOf((*MockOrderRepository)(nil).ListOrders), ctx, filter)
+}
+
 // SaveOrder mocks base method.
 func (m *MockOrderRepository) SaveOrder(ctx context.Context, order *models.Order) error {
 	m.ctrl.T.Helper()
@@ -207,3 +378,75 @@ func (mr *MockOrderRepositoryMockRecorder) SaveOrder(ctx, order any) *gomock.Cal
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveOrder", reflect.TypeOf((*MockOrderRepository)(nil).SaveOrder), ctx, order)
 }
+
+// SaveOrders mocks base method.
+func (m *MockOrderRepository) SaveOrders(ctx context.Context, orders []*models.Order) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveOrders", ctx, orders)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveOrders indicates an expected call of SaveOrders.
+func (mr *MockOrderRepositoryMockRecorder) SaveOrders(ctx, orders any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveOrders", reflect.TypeOf((*MockOrderRepository)(nil).SaveOrders), ctx, orders)
+}
+
+// SearchOrders mocks base method.
+func (m *MockOrderRepository) SearchOrders(ctx context.Context, query string, limit int) ([]*models.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchOrders", ctx, query, limit)
+	ret0, _ := ret[0].([]*models.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchOrders indicates an expected call of SearchOrders.
+func (mr *MockOrderRepositoryMockRecorder) SearchOrders(ctx, query, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchOrders", reflect.TypeOf((*MockOrderRepository)(nil).SearchOrders), ctx, query, limit)
+}
+
+// StreamFilteredOrders mocks base method.
+func (m *MockOrderRepository) StreamFilteredOrders(ctx context.Context, filter models.OrderFilter, batchSize, limit int, fn func([]*models.Order) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StreamFilteredOrders", ctx, filter, batchSize, limit, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StreamFilteredOrders indicates an expected call of StreamFilteredOrders.
+func (mr *MockOrderRepositoryMockRecorder) StreamFilteredOrders(ctx, filter, batchSize, limit, fn any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StreamFilteredOrders", reflect.TypeOf((*MockOrderRepository)(nil).StreamFilteredOrders), ctx, filter, batchSize, limit, fn)
+}
+
+// UpdateOrder mocks base method.
+func (m *MockOrderRepository) UpdateOrder(ctx context.Context, order *models.Order, expectedVersion int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateOrder", ctx, order, expectedVersion)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateOrder indicates an expected call of UpdateOrder.
+func (mr *MockOrderRepositoryMockRecorder) UpdateOrder(ctx, order, expectedVersion any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrder", reflect.TypeOf((*MockOrderRepository)(nil).UpdateOrder), ctx, order, expectedVersion)
+}
+
+// UpsertOrders mocks base method.
+func (m *MockOrderRepository) UpsertOrders(ctx context.Context, orders []*models.Order) ([]models.BatchResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertOrders", ctx, orders)
+	ret0, _ := ret[0].([]models.BatchResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpsertOrders indicates an expected call of UpsertOrders.
+func (mr *MockOrderRepositoryMockRecorder) UpsertOrders(ctx, orders any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertOrders", reflect.TypeOf((*MockOrderRepository)(nil).UpsertOrders), ctx, orders)
+}
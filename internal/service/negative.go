@@ -0,0 +1,108 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+const maxNegativeEntries = 10000
+
+// negativeCache remembers order UIDs the DB did not have, for a short TTL,
+// so repeated lookups of a bogus UID don't each hit the DB.
+//
+// A load that finds nothing may finish after the order was written, so loads
+// take a generation with Begin, and End only remembers the UID if it wasn't
+// deleted (written) or the cache cleared since.
+type negativeCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]time.Time
+
+	gen       uint64
+	clearedAt uint64
+	loading   int
+	// deletedAt holds the generation of deletes made while loads were in
+	// flight; it is emptied whenever no load is.
+	deletedAt map[string]uint64
+}
+
+func newNegativeCache(ttl time.Duration) *negativeCache {
+	return &negativeCache{
+		ttl:       ttl,
+		entries:   make(map[string]time.Time),
+		deletedAt: make(map[string]uint64),
+	}
+}
+
+func (n *negativeCache) Has(orderUID string) bool {
+	if n.ttl <= 0 {
+		return false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	expiresAt, ok := n.entries[orderUID]
+	if ok && time.Now().After(expiresAt) {
+		delete(n.entries, orderUID)
+		return false
+	}
+	return ok
+}
+
+// Begin starts a DB load and returns the generation to pass to End.
+func (n *negativeCache) Begin() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.loading++
+	return n.gen
+}
+
+// End finishes a load started at gen, remembering orderUID as missing if
+// the load didn't find it and nothing wrote it meanwhile.
+func (n *negativeCache) End(orderUID string, gen uint64, missing bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.loading--
+	if missing && n.ttl > 0 && n.clearedAt <= gen && n.deletedAt[orderUID] <= gen {
+		n.add(orderUID)
+	}
+	if n.loading == 0 {
+		clear(n.deletedAt)
+	}
+}
+
+func (n *negativeCache) add(orderUID string) {
+	now := time.Now()
+	if len(n.entries) >= maxNegativeEntries {
+		for uid, expiresAt := range n.entries {
+			if now.After(expiresAt) {
+				delete(n.entries, uid)
+			}
+		}
+		if len(n.entries) >= maxNegativeEntries {
+			return
+		}
+	}
+	n.entries[orderUID] = now.Add(n.ttl)
+}
+
+func (n *negativeCache) Delete(orderUID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.gen++
+	delete(n.entries, orderUID)
+	if n.loading > 0 {
+		n.deletedAt[orderUID] = n.gen
+	}
+}
+
+func (n *negativeCache) Clear() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.gen++
+	n.clearedAt = n.gen
+	clear(n.entries)
+}
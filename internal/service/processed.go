@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	processedCleanupInterval = time.Hour
+	processedCleanupBatch    = 1000
+)
+
+type ProcessedStore interface {
+	DeleteProcessedBefore(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+// ProcessedCleaner deletes idempotency keys older than the retention from
+// processed_messages. A message or request repeated after that is no longer
+// recognised as a duplicate, so the retention should outlast redeliveries.
+type ProcessedCleaner struct {
+	store     ProcessedStore
+	retention time.Duration
+	logger    *zap.Logger
+}
+
+func NewProcessedCleaner(store ProcessedStore, retention time.Duration, logger *zap.Logger) *ProcessedCleaner {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &ProcessedCleaner{store: store, retention: retention, logger: logger}
+}
+
+// Run cleans up once an hour until ctx is done.
+func (c *ProcessedCleaner) Run(ctx context.Context) error {
+	ticker := time.NewTicker(processedCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := c.CleanOnce(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			c.logger.Error("processed messages cleanup failed", zap.Error(err))
+		} else if n > 0 {
+			c.logger.Info("processed messages cleaned up", zap.Int("deleted", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// CleanOnce deletes keys processed before now minus the retention, in
+// batches so no single statement holds locks for long.
+func (c *ProcessedCleaner) CleanOnce(ctx context.Context, now time.Time) (int, error) {
+	before := now.Add(-c.retention)
+	total := 0
+	for {
+		n, err := c.store.DeleteProcessedBefore(ctx, before, processedCleanupBatch)
+		total += n
+		if err != nil || n < processedCleanupBatch {
+			return total, err
+		}
+	}
+}
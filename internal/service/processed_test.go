@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeProcessedStore struct {
+	remaining int
+	before    time.Time
+	calls     int
+	err       error
+}
+
+func (s *fakeProcessedStore) DeleteProcessedBefore(ctx context.Context, before time.Time, limit int) (int, error) {
+	s.calls++
+	s.before = before
+	if s.err != nil {
+		return 0, s.err
+	}
+	n := min(s.remaining, limit)
+	s.remaining -= n
+	return n, nil
+}
+
+func TestProcessedCleaner_DeletesInBatches(t *testing.T) {
+	store := &fakeProcessedStore{remaining: processedCleanupBatch*2 + 5}
+	cleaner := NewProcessedCleaner(store, 24*time.Hour, nil)
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+
+	n, err := cleaner.CleanOnce(context.Background(), now)
+	if err != nil {
+		t.Fatalf("CleanOnce returned error: %v", err)
+	}
+	if n != processedCleanupBatch*2+5 || store.calls != 3 {
+		t.Fatalf("expected all keys deleted in 3 batches, got %d in %d", n, store.calls)
+	}
+	if !store.before.Equal(now.Add(-24 * time.Hour)) {
+		t.Fatalf("unexpected cutoff %v", store.before)
+	}
+}
+
+func TestProcessedCleaner_StopsOnError(t *testing.T) {
+	store := &fakeProcessedStore{remaining: 10, err: errors.New("db down")}
+	cleaner := NewProcessedCleaner(store, time.Hour, nil)
+
+	if _, err := cleaner.CleanOnce(context.Background(), time.Now()); err == nil {
+		t.Fatal("expected error")
+	}
+	if store.calls != 1 {
+		t.Fatalf("expected a single attempt, got %d", store.calls)
+	}
+}
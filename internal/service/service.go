@@ -2,17 +2,55 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/lib/pq"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 
 	"wb-tech-1task/internal/models"
 )
 
 var (
-	ErrOrderNotFound = errors.New("order not found")
-	ErrOrderExists   = errors.New("order already exists")
+	ErrOrderNotFound   = errors.New("order not found")
+	ErrOrderExists     = errors.New("order already exists")
+	ErrVersionConflict = errors.New("order version conflict")
+	ErrInvalidOrder    = errors.New("invalid order")
+	ErrRawNotStored    = errors.New("raw payload not stored")
+
+	ErrCustomerNotFound = errors.New("customer not found")
+
+	ErrQueryTooShort = errors.New("search query too short")
+
+	ErrBatchTooLarge = errors.New("too many orders in batch")
+
+	ErrAlreadyProcessed     = errors.New("message already processed")
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused with a different request")
+)
+
+const (
+	DefaultListLimit = 20
+	MaxListLimit     = 100
+	// Lookups by track or rid matching at most LookupCacheLimit orders are
+	// cached; wider ones always come from the DB, so they don't push the
+	// orders looked up by UID out of the cache.
+	LookupCacheLimit = 20
+
+	DefaultNegativeTTL = 5 * time.Second
+
+	MinSearchQueryLen = 2
+
+	ExportBatchSize = 500
+
+	MaxImportOrders = 1000
+	// ImportChunkSize is how many orders of an import share a transaction.
+	ImportChunkSize = 100
 )
 
 type OrderCache interface {
@@ -22,30 +60,88 @@ type OrderCache interface {
 	Delete(orderUID string)
 	Count() int
 	DBBackup(orders []*models.Order) error
+	FindByTransaction(transaction string) []*models.Order
+	FindByTrack(trackNumber string) ([]*models.Order, bool)
+	FindByRid(rid string) ([]*models.Order, bool)
+	LookupGen() uint64
+	SetTrack(trackNumber string, orders []*models.Order, gen uint64)
+	SetRid(rid string, orders []*models.Order, gen uint64)
 }
 
 type OrderRepository interface {
 	GetOrder(ctx context.Context, orderUID string) (*models.Order, error)
 	SaveOrder(ctx context.Context, order *models.Order) error
+	SaveOrders(ctx context.Context, orders []*models.Order) error
+	UpdateOrder(ctx context.Context, order *models.Order, expectedVersion int) error
+	DeleteOrder(ctx context.Context, orderUID string, expectedVersion int) error
+	GetRawPayload(ctx context.Context, orderUID string) ([]byte, error)
+	GetOrderHistory(ctx context.Context, orderUID string) ([]models.OrderRevision, error)
+	GetProcessedMessage(ctx context.Context, key string) (*models.ProcessedMessage, error)
 	GetAllOrders(ctx context.Context) ([]*models.Order, error)
+	ListOrders(ctx context.Context, filter models.OrderFilter) ([]*models.Order, error)
+	UpsertOrders(ctx context.Context, orders []*models.Order) ([]models.BatchResult, error)
+	StreamFilteredOrders(ctx context.Context, filter models.OrderFilter, batchSize, limit int, fn func([]*models.Order) error) error
+	SearchOrders(ctx context.Context, query string, limit int) ([]*models.Order, error)
+	GetCustomerSummary(ctx context.Context, customerID string) (*models.CustomerSummary, error)
 	Close() error
 }
 
 type OrderService struct {
-	cache  OrderCache
-	repo   OrderRepository
-	logger *zap.Logger
+	cache    OrderCache
+	repo     OrderRepository
+	logger   *zap.Logger
+	loads    singleflight.Group
+	notFound *negativeCache
+	rules    *models.RuleEngine
 }
 
 func NewOrderService(cache OrderCache, repo OrderRepository, logger *zap.Logger) *OrderService {
+	return NewOrderServiceWithNegativeTTL(cache, repo, logger, DefaultNegativeTTL)
+}
+
+// NewOrderServiceWithNegativeTTL sets how long a UID missing from the DB is
+// answered as not found without asking the DB again; 0 disables it.
+func NewOrderServiceWithNegativeTTL(cache OrderCache, repo OrderRepository, logger *zap.Logger, negativeTTL time.Duration) *OrderService {
 	if logger == nil {
 		logger = zap.NewNop()
 	}
 	return &OrderService{
-		cache:  cache,
-		repo:   repo,
-		logger: logger,
+		cache:    cache,
+		repo:     repo,
+		logger:   logger,
+		notFound: newNegativeCache(negativeTTL),
+		rules:    models.DefaultRuleEngine(),
+	}
+}
+
+// SetRules replaces the business rules checked on every write, which are
+// models.DefaultRuleEngine otherwise. It must be called before the service
+// is used.
+func (s *OrderService) SetRules(rules *models.RuleEngine) {
+	s.rules = rules
+}
+
+// applyRules checks the business rules on an order about to be written and
+// records the violations of warn-mode rules on it.
+func (s *OrderService) applyRules(order *models.Order) error {
+	warnings, err := s.rules.Check(order)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidOrder, err)
 	}
+	order.Warnings = warnings
+	return nil
+}
+
+// ForgetNotFound drops the not-found mark of an order written elsewhere, e.g.
+// by another instance.
+func (s *OrderService) ForgetNotFound(orderUID string) {
+	s.notFound.Delete(orderUID)
+}
+
+// ClearNotFound drops every not-found mark, for when writes made elsewhere
+// may have been missed.
+func (s *OrderService) ClearNotFound() {
+	s.notFound.Clear()
 }
 
 func (s *OrderService) GetOrder(ctx context.Context, orderUID string) (*models.Order, error) {
@@ -57,28 +153,153 @@ func (s *OrderService) GetOrder(ctx context.Context, orderUID string) (*models.O
 		return order, nil
 	}
 
-	s.logger.Debug("cache miss; loading from db", zap.String("order_uid", orderUID))
-	order, err = s.repo.GetOrder(ctx, orderUID)
+	if s.notFound.Has(orderUID) {
+		return nil, ErrOrderNotFound
+	}
+
+	// concurrent misses for the same UID share one DB load, which must not
+	// fail for everyone if the first caller goes away
+	v, err, _ := s.loads.Do(orderUID, func() (any, error) {
+		s.logger.Debug("cache miss; loading from db", zap.String("order_uid", orderUID))
+		gen := s.notFound.Begin()
+		order, err := s.repo.GetOrder(context.WithoutCancel(ctx), orderUID)
+		s.notFound.End(orderUID, gen, errors.Is(err, ErrOrderNotFound))
+		if err != nil {
+			if errors.Is(err, ErrOrderNotFound) {
+				return nil, ErrOrderNotFound
+			}
+			s.logger.Error("repo.GetOrder failed", zap.String("order_uid", orderUID), zap.Error(err))
+			return nil, err
+		}
+
+		if err := s.cache.Set(order); err != nil {
+			s.logger.Warn("failed to set order to cache", zap.String("order_uid", orderUID), zap.Error(err))
+		}
+		return order, nil
+	})
 	if err != nil {
-		if errors.Is(err, ErrOrderNotFound) {
-			return nil, ErrOrderNotFound
+		return nil, err
+	}
+	return v.(*models.Order), nil
+}
+
+// GetOrdersByTrack pages through the orders with the given track number like
+// ListOrders; filter.TrackNumber is overridden.
+func (s *OrderService) GetOrdersByTrack(ctx context.Context, trackNumber string, filter models.OrderFilter) (*models.OrderPage, error) {
+	filter.TrackNumber = trackNumber
+	return s.lookupPage(ctx, "track_number", trackNumber, filter, s.cache.FindByTrack, s.cache.SetTrack)
+}
+
+// GetOrderByTransaction returns the order paid with the given transaction;
+// transactions are unique, so there is at most one and a cached one is
+// enough.
+func (s *OrderService) GetOrderByTransaction(ctx context.Context, transaction string) (*models.Order, error) {
+	if orders := s.cache.FindByTransaction(transaction); len(orders) > 0 {
+		s.logger.Debug("cache hit", zap.String("transaction", transaction))
+		return orders[0], nil
+	}
+
+	orders, err := s.repo.ListOrders(ctx, models.OrderFilter{Transaction: transaction, Limit: 1})
+	if err != nil {
+		s.logger.Error("repo.ListOrders failed", zap.String("transaction", transaction), zap.Error(err))
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, ErrOrderNotFound
+	}
+	if err := s.cache.Set(orders[0]); err != nil {
+		s.logger.Warn("failed to set order to cache", zap.String("order_uid", orders[0].OrderUID), zap.Error(err))
+	}
+	return orders[0], nil
+}
+
+// GetOrdersByRid pages through the orders containing an item with the given
+// rid like ListOrders; filter.Rid is overridden.
+func (s *OrderService) GetOrdersByRid(ctx context.Context, rid string, filter models.OrderFilter) (*models.OrderPage, error) {
+	filter.Rid = rid
+	return s.lookupPage(ctx, "rid", rid, filter, s.cache.FindByRid, s.cache.SetRid)
+}
+
+// lookupPage pages through a lookup by a non-unique key. The cache answers
+// it once it holds every order with key; otherwise the page comes from the
+// DB, and a first page holding all of at most LookupCacheLimit orders is
+// cached as complete. An empty first page is ErrOrderNotFound.
+func (s *OrderService) lookupPage(ctx context.Context, field, key string, filter models.OrderFilter,
+	find func(string) ([]*models.Order, bool), store func(string, []*models.Order, uint64)) (*models.OrderPage, error) {
+	keyOnly := lookupKeyOnly(filter)
+	if keyOnly {
+		if orders, complete := find(key); complete && len(orders) > 0 {
+			s.logger.Debug("cache hit", zap.String(field, key))
+			return cachedPage(orders, filter), nil
 		}
-		s.logger.Error("repo.GetOrder failed", zap.String("order_uid", orderUID), zap.Error(err))
+	}
+
+	gen := s.cache.LookupGen()
+	page, err := s.ListOrders(ctx, filter)
+	if err != nil {
 		return nil, err
 	}
+	if len(page.Orders) == 0 && filter.After == nil {
+		return nil, ErrOrderNotFound
+	}
 
-	if err := s.cache.Set(order); err != nil {
-		s.logger.Warn("failed to set order to cache", zap.String("order_uid", orderUID), zap.Error(err))
+	if keyOnly && filter.After == nil && page.NextCursor == "" && len(page.Orders) <= LookupCacheLimit {
+		store(key, page.Orders, gen)
 	}
-	return order, nil
+	return page, nil
+}
+
+// lookupKeyOnly reports whether filter narrows a track or rid lookup by its
+// key alone, so the cached orders of a complete key answer it.
+func lookupKeyOnly(filter models.OrderFilter) bool {
+	filter.After, filter.Limit = nil, 0
+	return filter == models.OrderFilter{TrackNumber: filter.TrackNumber} || filter == models.OrderFilter{Rid: filter.Rid}
+}
+
+// cachedPage pages through orders like ListOrders pages through the DB:
+// newest first by (date_created, order_uid), starting after filter.After.
+func cachedPage(orders []*models.Order, filter models.OrderFilter) *models.OrderPage {
+	sort.Slice(orders, func(i, j int) bool {
+		return listedBefore(orders[i].DateCreated, orders[i].OrderUID, orders[j].DateCreated, orders[j].OrderUID)
+	})
+
+	limit := listLimit(filter.Limit)
+	page := &models.OrderPage{Orders: []*models.Order{}}
+	for _, order := range orders {
+		if filter.After != nil && !listedBefore(filter.After.DateCreated, filter.After.OrderUID, order.DateCreated, order.OrderUID) {
+			continue
+		}
+		if len(page.Orders) == limit {
+			last := page.Orders[limit-1]
+			page.NextCursor = models.OrderCursor{DateCreated: last.DateCreated, OrderUID: last.OrderUID}.Encode()
+			break
+		}
+		page.Orders = append(page.Orders, order)
+	}
+	return page
+}
+
+// listedBefore reports whether the order (dateA, uidA) comes before the
+// order (dateB, uidB) in newest-first listing order.
+func listedBefore(dateA time.Time, uidA string, dateB time.Time, uidB string) bool {
+	if !dateA.Equal(dateB) {
+		return dateA.After(dateB)
+	}
+	return uidA > uidB
 }
 
 func (s *OrderService) SaveOrder(ctx context.Context, order *models.Order) error {
 	if order == nil {
 		return errors.New("nil order")
 	}
+	if err := s.applyRules(order); err != nil {
+		return err
+	}
 
 	if err := s.repo.SaveOrder(ctx, order); err != nil {
+		if errors.Is(err, ErrAlreadyProcessed) {
+			return err
+		}
 		var pqErr *pq.Error
 		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
 			s.logger.Info("unique violation on save", zap.String("order_uid", order.OrderUID), zap.Error(err))
@@ -88,6 +309,7 @@ func (s *OrderService) SaveOrder(ctx context.Context, order *models.Order) error
 		return err
 	}
 
+	s.notFound.Delete(order.OrderUID)
 	if err := s.cache.Set(order); err != nil {
 		s.logger.Warn("cache set failed after save", zap.String("order_uid", order.OrderUID), zap.Error(err))
 	}
@@ -96,6 +318,367 @@ func (s *OrderService) SaveOrder(ctx context.Context, order *models.Order) error
 	return nil
 }
 
+// SaveOrders creates all orders in one transaction, or none of them. Errors
+// are reported like SaveOrder's, for the batch as a whole.
+func (s *OrderService) SaveOrders(ctx context.Context, orders []*models.Order) error {
+	for _, order := range orders {
+		if err := s.applyRules(order); err != nil {
+			return err
+		}
+	}
+
+	if err := s.repo.SaveOrders(ctx, orders); err != nil {
+		if errors.Is(err, ErrAlreadyProcessed) {
+			return err
+		}
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrOrderExists
+		}
+		s.logger.Error("repo.SaveOrders failed", zap.Int("orders", len(orders)), zap.Error(err))
+		return err
+	}
+
+	for _, order := range orders {
+		s.notFound.Delete(order.OrderUID)
+		if err := s.cache.Set(order); err != nil {
+			s.logger.Warn("cache set failed after save", zap.String("order_uid", order.OrderUID), zap.Error(err))
+		}
+	}
+	s.logger.Info("orders saved", zap.Int("orders", len(orders)))
+	return nil
+}
+
+// CreateOrderIdempotent saves order once per idempotency key. Repeating the
+// key with the same request hash returns the order stored the first time and
+// true; repeating it with a different request fails with ErrIdempotencyKeyReused.
+func (s *OrderService) CreateOrderIdempotent(ctx context.Context, order *models.Order, key, requestHash string) (*models.Order, bool, error) {
+	src := models.ChangeSourceFrom(ctx)
+	src.IdempotencyKey = "http:" + key
+	src.RequestHash = requestHash
+
+	err := s.SaveOrder(models.WithChangeSource(ctx, src), order)
+	if err == nil {
+		return order, false, nil
+	}
+	if !errors.Is(err, ErrAlreadyProcessed) {
+		return nil, false, err
+	}
+
+	processed, err := s.repo.GetProcessedMessage(ctx, src.IdempotencyKey)
+	if err != nil {
+		s.logger.Error("repo.GetProcessedMessage failed", zap.String("key", key), zap.Error(err))
+		return nil, false, err
+	}
+	if processed == nil || processed.RequestHash != requestHash {
+		return nil, false, ErrIdempotencyKeyReused
+	}
+
+	saved, err := s.GetOrder(ctx, processed.OrderUID)
+	if err != nil {
+		return nil, false, err
+	}
+	return saved, true, nil
+}
+
+// ImportOrders creates or replaces the given order documents, ImportChunkSize
+// per transaction, and reports the outcome of each. Documents that don't
+// decode, validate or pass the strict business rules are reported as invalid
+// and not sent to the DB; a chunk whose transaction fails is reported as
+// failed.
+func (s *OrderService) ImportOrders(ctx context.Context, docs []json.RawMessage) (*models.BatchReport, error) {
+	if len(docs) > MaxImportOrders {
+		return nil, ErrBatchTooLarge
+	}
+
+	report := &models.BatchReport{Results: make([]models.BatchResult, len(docs))}
+	var (
+		chunk   []*models.Order
+		indexes []int
+	)
+	flush := func() {
+		results, err := s.repo.UpsertOrders(ctx, chunk)
+		if err != nil {
+			s.logger.Error("repo.UpsertOrders failed", zap.Int("orders", len(chunk)), zap.Error(err))
+		}
+		for j, i := range indexes {
+			if err != nil {
+				report.Results[i] = models.BatchResult{OrderUID: chunk[j].OrderUID, Status: models.BatchFailed, Error: "internal error"}
+				continue
+			}
+			report.Results[i] = results[j]
+			if results[j].Status != models.BatchInvalid {
+				s.notFound.Delete(chunk[j].OrderUID)
+				if err := s.cache.Set(chunk[j]); err != nil {
+					s.cache.Delete(chunk[j].OrderUID)
+				}
+			}
+		}
+		chunk, indexes = nil, nil
+	}
+
+	for i, doc := range docs {
+		var order models.Order
+		if err := json.Unmarshal(doc, &order); err != nil {
+			report.Results[i] = models.BatchResult{Status: models.BatchInvalid, Error: err.Error()}
+			continue
+		}
+		err := order.Validate()
+		if err == nil {
+			err = s.applyRules(&order)
+		}
+		if err != nil {
+			res := models.BatchResult{OrderUID: order.OrderUID, Status: models.BatchInvalid, Error: err.Error()}
+			var verr *models.ValidationError
+			if errors.As(err, &verr) {
+				res.Violations = verr.Violations
+			}
+			report.Results[i] = res
+			continue
+		}
+		order.RawPayload = doc
+		chunk = append(chunk, &order)
+		indexes = append(indexes, i)
+		if len(chunk) == ImportChunkSize {
+			flush()
+		}
+	}
+	if len(chunk) > 0 {
+		flush()
+	}
+
+	for i := range report.Results {
+		res := &report.Results[i]
+		res.Index = i
+		switch res.Status {
+		case models.BatchCreated:
+			report.Created++
+		case models.BatchUpdated:
+			report.Updated++
+		case models.BatchInvalid:
+			report.Invalid++
+		default:
+			report.Failed++
+		}
+	}
+	s.logger.Info("orders imported", zap.Int("created", report.Created), zap.Int("updated", report.Updated),
+		zap.Int("invalid", report.Invalid), zap.Int("failed", report.Failed))
+	return report, nil
+}
+
+// UpdateOrder replaces an existing order. expectedVersion 0 skips the
+// version check.
+func (s *OrderService) UpdateOrder(ctx context.Context, order *models.Order, expectedVersion int) error {
+	if order == nil {
+		return errors.New("nil order")
+	}
+	if err := s.applyRules(order); err != nil {
+		return err
+	}
+
+	if err := s.repo.UpdateOrder(ctx, order, expectedVersion); err != nil {
+		if errors.Is(err, ErrOrderNotFound) || errors.Is(err, ErrVersionConflict) || errors.Is(err, ErrAlreadyProcessed) {
+			return err
+		}
+		s.logger.Error("repo.UpdateOrder failed", zap.String("order_uid", order.OrderUID), zap.Error(err))
+		return err
+	}
+
+	s.notFound.Delete(order.OrderUID)
+	if err := s.cache.Set(order); err != nil {
+		s.cache.Delete(order.OrderUID)
+		s.logger.Warn("cache set failed after update", zap.String("order_uid", order.OrderUID), zap.Error(err))
+	}
+
+	s.logger.Info("order updated", zap.String("order_uid", order.OrderUID), zap.Int("version", order.Version))
+	return nil
+}
+
+// PatchOrder applies a JSON merge patch to the stored order and saves the
+// result, failing with ErrVersionConflict if the order changed meanwhile. The
+// patch is applied to the raw payload too, so GetRawPayload keeps returning
+// the document the order is stored as.
+func (s *OrderService) PatchOrder(ctx context.Context, orderUID string, patch []byte, expectedVersion int) (*models.Order, error) {
+	current, err := s.repo.GetOrder(ctx, orderUID)
+	if err != nil {
+		return nil, err
+	}
+	if expectedVersion != 0 && current.Version != expectedVersion {
+		return nil, ErrVersionConflict
+	}
+
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := models.MergePatch(doc, patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOrder, err)
+	}
+
+	var order models.Order
+	if err := json.Unmarshal(merged, &order); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOrder, err)
+	}
+	if order.OrderUID != orderUID {
+		return nil, fmt.Errorf("%w: order_uid cannot be changed", ErrInvalidOrder)
+	}
+	if err := order.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidOrder, err)
+	}
+
+	// Orders stored before raw payloads were kept get the merged model.
+	order.RawPayload = merged
+	raw, err := s.repo.GetRawPayload(ctx, orderUID)
+	switch {
+	case err == nil:
+		if order.RawPayload, err = models.MergePatch(raw, patch); err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, ErrRawNotStored):
+		return nil, err
+	}
+
+	if err := s.UpdateOrder(ctx, &order, current.Version); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func (s *OrderService) DeleteOrder(ctx context.Context, orderUID string, expectedVersion int) error {
+	if err := s.repo.DeleteOrder(ctx, orderUID, expectedVersion); err != nil {
+		if errors.Is(err, ErrOrderNotFound) || errors.Is(err, ErrVersionConflict) || errors.Is(err, ErrAlreadyProcessed) {
+			return err
+		}
+		s.logger.Error("repo.DeleteOrder failed", zap.String("order_uid", orderUID), zap.Error(err))
+		return err
+	}
+
+	s.cache.Delete(orderUID)
+	s.logger.Info("order deleted", zap.String("order_uid", orderUID))
+	return nil
+}
+
+// GetRawPayload returns the document the order was last created or replaced
+// with, as received from Kafka or HTTP, with any later patches applied.
+func (s *OrderService) GetRawPayload(ctx context.Context, orderUID string) ([]byte, error) {
+	raw, err := s.repo.GetRawPayload(ctx, orderUID)
+	if err != nil && !errors.Is(err, ErrOrderNotFound) && !errors.Is(err, ErrRawNotStored) {
+		s.logger.Error("repo.GetRawPayload failed", zap.String("order_uid", orderUID), zap.Error(err))
+	}
+	return raw, err
+}
+
+// GetOrderHistory returns the order's revisions, each with the field-level
+// changes against the previous snapshot.
+func (s *OrderService) GetOrderHistory(ctx context.Context, orderUID string) (*models.OrderHistory, error) {
+	revisions, err := s.repo.GetOrderHistory(ctx, orderUID)
+	if err != nil {
+		s.logger.Error("repo.GetOrderHistory failed", zap.String("order_uid", orderUID), zap.Error(err))
+		return nil, err
+	}
+	if len(revisions) == 0 {
+		return nil, ErrOrderNotFound
+	}
+
+	var prev []byte
+	for i := range revisions {
+		rev := &revisions[i]
+		if prev != nil && rev.Snapshot != nil {
+			changes, err := models.DiffJSON(prev, rev.Snapshot)
+			if err != nil {
+				return nil, err
+			}
+			rev.Changes = withoutVersion(changes)
+		}
+		prev = rev.Snapshot
+	}
+	return &models.OrderHistory{OrderUID: orderUID, Revisions: revisions}, nil
+}
+
+func withoutVersion(changes []models.FieldChange) []models.FieldChange {
+	out := changes[:0]
+	for _, c := range changes {
+		if c.Path != "version" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
 func (s *OrderService) GetAllOrders(ctx context.Context) ([]*models.Order, error) {
 	return s.repo.GetAllOrders(ctx)
 }
+
+func (s *OrderService) ListOrders(ctx context.Context, filter models.OrderFilter) (*models.OrderPage, error) {
+	limit := listLimit(filter.Limit)
+	filter.Limit = limit + 1
+
+	orders, err := s.repo.ListOrders(ctx, filter)
+	if err != nil {
+		s.logger.Error("repo.ListOrders failed", zap.Error(err))
+		return nil, err
+	}
+
+	page := &models.OrderPage{Orders: orders}
+	if len(orders) > limit {
+		page.Orders = orders[:limit]
+		last := page.Orders[limit-1]
+		page.NextCursor = models.OrderCursor{DateCreated: last.DateCreated, OrderUID: last.OrderUID}.Encode()
+	}
+	if page.Orders == nil {
+		page.Orders = []*models.Order{}
+	}
+	return page, nil
+}
+
+// ExportOrders passes the orders matching filter to fn batch by batch, newest
+// first, without loading them all at once. filter.Limit caps the total number
+// of orders; 0 exports all of them.
+func (s *OrderService) ExportOrders(ctx context.Context, filter models.OrderFilter, fn func([]*models.Order) error) error {
+	return s.repo.StreamFilteredOrders(ctx, filter, ExportBatchSize, filter.Limit, fn)
+}
+
+// ListCustomerOrders pages through one customer's orders like ListOrders;
+// filter.CustomerID is overridden.
+func (s *OrderService) ListCustomerOrders(ctx context.Context, customerID string, filter models.OrderFilter) (*models.OrderPage, error) {
+	filter.CustomerID = customerID
+	return s.ListOrders(ctx, filter)
+}
+
+func (s *OrderService) GetCustomerSummary(ctx context.Context, customerID string) (*models.CustomerSummary, error) {
+	summary, err := s.repo.GetCustomerSummary(ctx, customerID)
+	if err != nil {
+		s.logger.Error("repo.GetCustomerSummary failed", zap.String("customer_id", customerID), zap.Error(err))
+		return nil, err
+	}
+	if summary.OrderCount == 0 {
+		return nil, ErrCustomerNotFound
+	}
+	return summary, nil
+}
+
+func (s *OrderService) SearchOrders(ctx context.Context, query string, limit int) (*models.OrderPage, error) {
+	query = strings.TrimSpace(query)
+	if utf8.RuneCountInString(query) < MinSearchQueryLen {
+		return nil, ErrQueryTooShort
+	}
+	limit = listLimit(limit)
+
+	orders, err := s.repo.SearchOrders(ctx, query, limit)
+	if err != nil {
+		s.logger.Error("repo.SearchOrders failed", zap.String("query", query), zap.Error(err))
+		return nil, err
+	}
+	if orders == nil {
+		orders = []*models.Order{}
+	}
+	return &models.OrderPage{Orders: orders}, nil
+}
+
+func listLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultListLimit
+	}
+	return min(limit, MaxListLimit)
+}
@@ -2,7 +2,11 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,6 +20,15 @@ type mockRepo struct {
 	saveFunc    func(ctx context.Context, order *models.Order) error
 	getFunc     func(ctx context.Context, uid string) (*models.Order, error)
 	getAllFunc  func(ctx context.Context) ([]*models.Order, error)
+	listFunc    func(ctx context.Context, filter models.OrderFilter) ([]*models.Order, error)
+	updateFunc  func(ctx context.Context, order *models.Order, expectedVersion int) error
+	deleteFunc  func(ctx context.Context, uid string, expectedVersion int) error
+	rawFunc     func(ctx context.Context, uid string) ([]byte, error)
+	historyFunc func(ctx context.Context, uid string) ([]models.OrderRevision, error)
+	processed   map[string]*models.ProcessedMessage
+	searchFunc  func(ctx context.Context, query string, limit int) ([]*models.Order, error)
+	summaryFunc func(ctx context.Context, customerID string) (*models.CustomerSummary, error)
+	upsertFunc  func(ctx context.Context, orders []*models.Order) ([]models.BatchResult, error)
 	closeCalled bool
 }
 
@@ -31,12 +44,78 @@ func (m *mockRepo) SaveOrder(ctx context.Context, order *models.Order) error {
 	}
 	return nil
 }
+func (m *mockRepo) SaveOrders(ctx context.Context, orders []*models.Order) error {
+	for _, o := range orders {
+		if err := m.SaveOrder(ctx, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (m *mockRepo) UpdateOrder(ctx context.Context, order *models.Order, expectedVersion int) error {
+	if m.updateFunc != nil {
+		return m.updateFunc(ctx, order, expectedVersion)
+	}
+	return nil
+}
+func (m *mockRepo) DeleteOrder(ctx context.Context, orderUID string, expectedVersion int) error {
+	if m.deleteFunc != nil {
+		return m.deleteFunc(ctx, orderUID, expectedVersion)
+	}
+	return nil
+}
+func (m *mockRepo) GetRawPayload(ctx context.Context, orderUID string) ([]byte, error) {
+	if m.rawFunc != nil {
+		return m.rawFunc(ctx, orderUID)
+	}
+	return nil, ErrRawNotStored
+}
+func (m *mockRepo) GetOrderHistory(ctx context.Context, orderUID string) ([]models.OrderRevision, error) {
+	if m.historyFunc != nil {
+		return m.historyFunc(ctx, orderUID)
+	}
+	return nil, nil
+}
+func (m *mockRepo) GetProcessedMessage(ctx context.Context, key string) (*models.ProcessedMessage, error) {
+	return m.processed[key], nil
+}
+func (m *mockRepo) SearchOrders(ctx context.Context, query string, limit int) ([]*models.Order, error) {
+	if m.searchFunc != nil {
+		return m.searchFunc(ctx, query, limit)
+	}
+	return nil, nil
+}
+func (m *mockRepo) GetCustomerSummary(ctx context.Context, customerID string) (*models.CustomerSummary, error) {
+	if m.summaryFunc != nil {
+		return m.summaryFunc(ctx, customerID)
+	}
+	return &models.CustomerSummary{CustomerID: customerID}, nil
+}
 func (m *mockRepo) GetAllOrders(ctx context.Context) ([]*models.Order, error) {
 	if m.getAllFunc != nil {
 		return m.getAllFunc(ctx)
 	}
 	return nil, nil
 }
+func (m *mockRepo) ListOrders(ctx context.Context, filter models.OrderFilter) ([]*models.Order, error) {
+	if m.listFunc != nil {
+		return m.listFunc(ctx, filter)
+	}
+	return nil, nil
+}
+func (m *mockRepo) UpsertOrders(ctx context.Context, orders []*models.Order) ([]models.BatchResult, error) {
+	if m.upsertFunc != nil {
+		return m.upsertFunc(ctx, orders)
+	}
+	results := make([]models.BatchResult, len(orders))
+	for i, o := range orders {
+		results[i] = models.BatchResult{OrderUID: o.OrderUID, Status: models.BatchCreated, Version: 1}
+	}
+	return results, nil
+}
+func (m *mockRepo) StreamFilteredOrders(ctx context.Context, filter models.OrderFilter, batchSize, limit int, fn func([]*models.Order) error) error {
+	return nil
+}
 func (m *mockRepo) Close() error {
 	m.closeCalled = true
 	return nil
@@ -46,6 +125,11 @@ type mockCache struct {
 	setFunc func(order *models.Order) error
 	getFunc func(uid string) (*models.Order, bool, error)
 	dbFunc  func(orders []*models.Order) error
+	deleted []string
+	indexed map[string][]*models.Order
+	// complete lists the indexed track and rid keys the cache holds in full
+	complete map[string]bool
+	stored   map[string][]*models.Order
 }
 
 func (m *mockCache) Set(order *models.Order) error {
@@ -63,8 +147,30 @@ func (m *mockCache) Get(uid string) (*models.Order, bool, error) {
 func (m *mockCache) GetAll() (map[string]*models.Order, error) {
 	return nil, nil
 }
-func (m *mockCache) Delete(uid string) { return }
+func (m *mockCache) Delete(uid string) { m.deleted = append(m.deleted, uid) }
 func (m *mockCache) Count() int        { return 0 }
+func (m *mockCache) FindByTransaction(transaction string) []*models.Order {
+	return m.indexed[transaction]
+}
+func (m *mockCache) FindByTrack(trackNumber string) ([]*models.Order, bool) {
+	return m.indexed[trackNumber], m.complete[trackNumber]
+}
+func (m *mockCache) FindByRid(rid string) ([]*models.Order, bool) {
+	return m.indexed[rid], m.complete[rid]
+}
+func (m *mockCache) LookupGen() uint64 { return 0 }
+func (m *mockCache) SetTrack(trackNumber string, orders []*models.Order, gen uint64) {
+	m.store(trackNumber, orders)
+}
+func (m *mockCache) SetRid(rid string, orders []*models.Order, gen uint64) {
+	m.store(rid, orders)
+}
+func (m *mockCache) store(key string, orders []*models.Order) {
+	if m.stored == nil {
+		m.stored = make(map[string][]*models.Order)
+	}
+	m.stored[key] = orders
+}
 func (m *mockCache) DBBackup(orders []*models.Order) error {
 	if m.dbFunc != nil {
 		return m.dbFunc(orders)
@@ -145,6 +251,47 @@ func TestSaveOrder_Success(t *testing.T) {
 	}
 }
 
+func TestSaveOrder_BusinessRules(t *testing.T) {
+	ctx := context.Background()
+
+	var saved *models.Order
+	repo := &mockRepo{
+		saveFunc: func(ctx context.Context, o *models.Order) error {
+			saved = o
+			return nil
+		},
+	}
+	cache := &mockCache{setFunc: func(o *models.Order) error { return nil }}
+	svc := NewOrderService(cache, repo, zap.NewNop())
+
+	// goods_total 90 against an item total of 100, transaction unrelated to the uid
+	if err := svc.SaveOrder(ctx, sampleOrder()); err != nil {
+		t.Fatalf("expected nil error in warn mode, got %v", err)
+	}
+	if len(saved.Warnings) != 2 || saved.Warnings[0].Rule != models.RuleGoodsTotal || saved.Warnings[1].Rule != models.RuleTransaction {
+		t.Fatalf("expected goods_total and transaction warnings, got %+v", saved.Warnings)
+	}
+
+	rules := models.DefaultRuleEngine()
+	if err := rules.SetModes([]string{"goods_total=strict"}); err != nil {
+		t.Fatalf("SetModes: %v", err)
+	}
+	svc.SetRules(rules)
+	saved = nil
+
+	err := svc.SaveOrder(ctx, sampleOrder())
+	var verr *models.ValidationError
+	if !errors.Is(err, ErrInvalidOrder) || !errors.As(err, &verr) {
+		t.Fatalf("expected ErrInvalidOrder with violations, got %v", err)
+	}
+	if len(verr.Violations) != 1 || verr.Violations[0].Path != "payment.goods_total" {
+		t.Fatalf("unexpected violations %+v", verr.Violations)
+	}
+	if saved != nil {
+		t.Fatalf("order breaking a strict rule must not be saved")
+	}
+}
+
 func TestSaveOrder_DBUniqueViolation(t *testing.T) {
 	ctx := context.Background()
 	order := sampleOrder()
@@ -211,3 +358,553 @@ func TestSaveOrder_DBError_Propagated(t *testing.T) {
 		t.Fatalf("expected db down error, got %v", err)
 	}
 }
+
+func TestListOrders_SetsNextCursorWhenMoreAvailable(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+
+	var gotLimit int
+	repo := &mockRepo{
+		listFunc: func(ctx context.Context, filter models.OrderFilter) ([]*models.Order, error) {
+			gotLimit = filter.Limit
+			return []*models.Order{
+				{OrderUID: "o-3", DateCreated: base.Add(2 * time.Hour)},
+				{OrderUID: "o-2", DateCreated: base.Add(time.Hour)},
+				{OrderUID: "o-1", DateCreated: base},
+			}, nil
+		},
+	}
+	svc := NewOrderService(&mockCache{}, repo, zap.NewNop())
+
+	page, err := svc.ListOrders(ctx, models.OrderFilter{Limit: 2})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if gotLimit != 3 {
+		t.Fatalf("expected repo to be asked for limit+1=3 rows, got %d", gotLimit)
+	}
+	if len(page.Orders) != 2 {
+		t.Fatalf("expected 2 orders on page, got %d", len(page.Orders))
+	}
+
+	cursor, err := models.DecodeOrderCursor(page.NextCursor)
+	if err != nil {
+		t.Fatalf("next cursor is not decodable: %v", err)
+	}
+	if cursor.OrderUID != "o-2" || !cursor.DateCreated.Equal(base.Add(time.Hour)) {
+		t.Fatalf("unexpected cursor %+v", cursor)
+	}
+}
+
+func TestListOrders_LastPageHasNoCursor(t *testing.T) {
+	repo := &mockRepo{
+		listFunc: func(ctx context.Context, filter models.OrderFilter) ([]*models.Order, error) {
+			return []*models.Order{{OrderUID: "o-1", DateCreated: time.Now()}}, nil
+		},
+	}
+	svc := NewOrderService(&mockCache{}, repo, zap.NewNop())
+
+	page, err := svc.ListOrders(context.Background(), models.OrderFilter{})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if page.NextCursor != "" {
+		t.Fatalf("expected empty next cursor, got %q", page.NextCursor)
+	}
+}
+
+func TestUpdateOrder_VersionConflictLeavesCache(t *testing.T) {
+	repo := &mockRepo{
+		updateFunc: func(ctx context.Context, o *models.Order, expectedVersion int) error {
+			if expectedVersion != 2 {
+				t.Fatalf("expected version 2 to be passed to repo, got %d", expectedVersion)
+			}
+			return ErrVersionConflict
+		},
+	}
+	cache := &mockCache{
+		setFunc: func(o *models.Order) error {
+			t.Fatalf("cache.Set should not be called on version conflict")
+			return nil
+		},
+	}
+	svc := NewOrderService(cache, repo, zap.NewNop())
+
+	err := svc.UpdateOrder(context.Background(), sampleOrder(), 2)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+}
+
+func TestPatchOrder_MergesAndUpdatesWithStoredVersion(t *testing.T) {
+	stored := sampleOrder()
+	stored.Version = 3
+
+	var updated *models.Order
+	var gotVersion int
+	repo := &mockRepo{
+		getFunc: func(ctx context.Context, uid string) (*models.Order, error) {
+			return stored, nil
+		},
+		updateFunc: func(ctx context.Context, o *models.Order, expectedVersion int) error {
+			updated, gotVersion = o, expectedVersion
+			o.Version = expectedVersion + 1
+			return nil
+		},
+	}
+	svc := NewOrderService(&mockCache{}, repo, zap.NewNop())
+
+	order, err := svc.PatchOrder(context.Background(), "o-123", []byte(`{"delivery":{"city":"Kazan"}}`), 3)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if gotVersion != 3 || order.Version != 4 {
+		t.Fatalf("unexpected versions: passed %d, result %d", gotVersion, order.Version)
+	}
+	if updated.Delivery.City != "Kazan" || updated.Delivery.Name != stored.Delivery.Name {
+		t.Fatalf("patch not merged: %+v", updated.Delivery)
+	}
+	var raw models.Order
+	if err := json.Unmarshal(updated.RawPayload, &raw); err != nil || raw.Delivery.City != "Kazan" {
+		t.Fatalf("raw payload not patched: %s", updated.RawPayload)
+	}
+}
+
+func TestPatchOrder_PatchesRawPayload(t *testing.T) {
+	var updated *models.Order
+	repo := &mockRepo{
+		getFunc: func(ctx context.Context, uid string) (*models.Order, error) {
+			return sampleOrder(), nil
+		},
+		rawFunc: func(ctx context.Context, uid string) ([]byte, error) {
+			return []byte(`{"order_uid":"o-123","delivery":{"city":"Moscow","zip":"101000"},"extra":1}`), nil
+		},
+		updateFunc: func(ctx context.Context, o *models.Order, expectedVersion int) error {
+			updated = o
+			return nil
+		},
+	}
+	svc := NewOrderService(&mockCache{}, repo, zap.NewNop())
+
+	if _, err := svc.PatchOrder(context.Background(), "o-123", []byte(`{"delivery":{"city":"Kazan"}}`), 0); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	want := `{"delivery":{"city":"Kazan","zip":"101000"},"extra":1,"order_uid":"o-123"}`
+	if string(updated.RawPayload) != want {
+		t.Fatalf("raw payload = %s, want %s", updated.RawPayload, want)
+	}
+}
+
+func TestPatchOrder_StaleVersion(t *testing.T) {
+	stored := sampleOrder()
+	stored.Version = 5
+	repo := &mockRepo{
+		getFunc: func(ctx context.Context, uid string) (*models.Order, error) {
+			return stored, nil
+		},
+		updateFunc: func(ctx context.Context, o *models.Order, expectedVersion int) error {
+			t.Fatalf("repo.UpdateOrder should not be called with a stale version")
+			return nil
+		},
+	}
+	svc := NewOrderService(&mockCache{}, repo, zap.NewNop())
+
+	_, err := svc.PatchOrder(context.Background(), "o-123", []byte(`{"entry":"X"}`), 4)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+}
+
+func TestPatchOrder_InvalidResult(t *testing.T) {
+	repo := &mockRepo{
+		getFunc: func(ctx context.Context, uid string) (*models.Order, error) {
+			return sampleOrder(), nil
+		},
+	}
+	svc := NewOrderService(&mockCache{}, repo, zap.NewNop())
+
+	_, err := svc.PatchOrder(context.Background(), "o-123", []byte(`{"items":null}`), 0)
+	if !errors.Is(err, ErrInvalidOrder) {
+		t.Fatalf("expected ErrInvalidOrder, got %v", err)
+	}
+	_, err = svc.PatchOrder(context.Background(), "o-123", []byte(`{"order_uid":"other"}`), 0)
+	if !errors.Is(err, ErrInvalidOrder) {
+		t.Fatalf("expected ErrInvalidOrder for changed order_uid, got %v", err)
+	}
+}
+
+func TestDeleteOrder_EvictsFromCache(t *testing.T) {
+	cache := &mockCache{}
+	svc := NewOrderService(cache, &mockRepo{}, zap.NewNop())
+
+	if err := svc.DeleteOrder(context.Background(), "o-123", 0); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if len(cache.deleted) != 1 || cache.deleted[0] != "o-123" {
+		t.Fatalf("expected order to be evicted from cache, got %v", cache.deleted)
+	}
+}
+
+func TestGetOrderHistory_DiffsConsecutiveSnapshots(t *testing.T) {
+	repo := &mockRepo{
+		historyFunc: func(ctx context.Context, uid string) ([]models.OrderRevision, error) {
+			return []models.OrderRevision{
+				{Version: 1, Operation: models.ChangeCreate, Snapshot: []byte(`{"version":1,"delivery":{"city":"A"}}`)},
+				{Version: 2, Operation: models.ChangeUpdate, Snapshot: []byte(`{"version":2,"delivery":{"city":"B"}}`)},
+				{Version: 2, Operation: models.ChangeDelete},
+				{Version: 1, Operation: models.ChangeCreate, Snapshot: []byte(`{"version":1,"delivery":{"city":"C"}}`)},
+			}, nil
+		},
+	}
+	svc := NewOrderService(&mockCache{}, repo, zap.NewNop())
+
+	history, err := svc.GetOrderHistory(context.Background(), "o-123")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	revs := history.Revisions
+	if len(revs[0].Changes) != 0 || len(revs[3].Changes) != 0 {
+		t.Fatalf("expected no diff for creates, got %+v / %+v", revs[0].Changes, revs[3].Changes)
+	}
+	if len(revs[1].Changes) != 1 || revs[1].Changes[0].Path != "delivery.city" ||
+		revs[1].Changes[0].Old != "A" || revs[1].Changes[0].New != "B" {
+		t.Fatalf("unexpected update diff %+v", revs[1].Changes)
+	}
+}
+
+func TestGetOrderHistory_EmptyIsNotFound(t *testing.T) {
+	svc := NewOrderService(&mockCache{}, &mockRepo{}, zap.NewNop())
+
+	if _, err := svc.GetOrderHistory(context.Background(), "missing"); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("expected ErrOrderNotFound, got %v", err)
+	}
+}
+
+func TestCreateOrderIdempotent_ReplaysSameRequest(t *testing.T) {
+	stored := sampleOrder()
+	repo := &mockRepo{
+		saveFunc: func(ctx context.Context, o *models.Order) error {
+			if key := models.ChangeSourceFrom(ctx).IdempotencyKey; key != "http:k1" {
+				t.Fatalf("expected idempotency key in context, got %q", key)
+			}
+			return ErrAlreadyProcessed
+		},
+		getFunc: func(ctx context.Context, uid string) (*models.Order, error) {
+			return stored, nil
+		},
+		processed: map[string]*models.ProcessedMessage{
+			"http:k1": {Key: "http:k1", OrderUID: stored.OrderUID, RequestHash: "h1"},
+		},
+	}
+	svc := NewOrderService(&mockCache{}, repo, zap.NewNop())
+
+	order, replayed, err := svc.CreateOrderIdempotent(context.Background(), sampleOrder(), "k1", "h1")
+	if err != nil || !replayed || order != stored {
+		t.Fatalf("expected stored order to be replayed, got %v, %v, %v", order, replayed, err)
+	}
+
+	_, _, err = svc.CreateOrderIdempotent(context.Background(), sampleOrder(), "k1", "h2")
+	if !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Fatalf("expected ErrIdempotencyKeyReused, got %v", err)
+	}
+}
+
+func TestGetOrder_ConcurrentMissesShareOneLoad(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	repo := &mockRepo{
+		getFunc: func(ctx context.Context, uid string) (*models.Order, error) {
+			calls.Add(1)
+			<-release
+			return sampleOrder(), nil
+		},
+	}
+	svc := NewOrderService(&mockCache{}, repo, zap.NewNop())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := svc.GetOrder(context.Background(), "o-123"); err != nil {
+				t.Errorf("expected nil error, got %v", err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected a single repo load, got %d", n)
+	}
+}
+
+func TestGetOrder_NotFoundIsCachedUntilSaved(t *testing.T) {
+	calls := 0
+	repo := &mockRepo{
+		getFunc: func(ctx context.Context, uid string) (*models.Order, error) {
+			calls++
+			return nil, ErrOrderNotFound
+		},
+	}
+	svc := NewOrderService(&mockCache{}, repo, zap.NewNop())
+
+	for i := 0; i < 3; i++ {
+		if _, err := svc.GetOrder(context.Background(), "o-123"); !errors.Is(err, ErrOrderNotFound) {
+			t.Fatalf("expected ErrOrderNotFound, got %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected not-found to be cached after one repo call, got %d", calls)
+	}
+
+	if err := svc.SaveOrder(context.Background(), sampleOrder()); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	svc.GetOrder(context.Background(), "o-123")
+	if calls != 2 {
+		t.Fatalf("expected saving the order to drop the negative entry, got %d repo calls", calls)
+	}
+}
+
+func TestGetOrder_WriteDuringLoadIsNotMarkedMissing(t *testing.T) {
+	calls := 0
+	var svc *OrderService
+	repo := &mockRepo{
+		getFunc: func(ctx context.Context, uid string) (*models.Order, error) {
+			calls++
+			if calls == 1 {
+				// another instance writes the order while the lookup is in flight
+				svc.ForgetNotFound(uid)
+			}
+			return nil, ErrOrderNotFound
+		},
+	}
+	svc = NewOrderService(&mockCache{}, repo, zap.NewNop())
+
+	svc.GetOrder(context.Background(), "o-123")
+	svc.GetOrder(context.Background(), "o-123")
+	if calls != 2 {
+		t.Fatalf("expected the second lookup to reach the repo, got %d repo calls", calls)
+	}
+
+	svc.GetOrder(context.Background(), "o-123")
+	if calls != 2 {
+		t.Fatalf("expected the second not-found to be cached, got %d repo calls", calls)
+	}
+	svc.ClearNotFound()
+	svc.GetOrder(context.Background(), "o-123")
+	if calls != 3 {
+		t.Fatalf("expected ClearNotFound to drop the negative entry, got %d repo calls", calls)
+	}
+}
+
+func TestGetOrder_NegativeCacheDisabled(t *testing.T) {
+	calls := 0
+	repo := &mockRepo{
+		getFunc: func(ctx context.Context, uid string) (*models.Order, error) {
+			calls++
+			return nil, ErrOrderNotFound
+		},
+	}
+	svc := NewOrderServiceWithNegativeTTL(&mockCache{}, repo, zap.NewNop(), 0)
+
+	svc.GetOrder(context.Background(), "missing")
+	svc.GetOrder(context.Background(), "missing")
+	if calls != 2 {
+		t.Fatalf("expected every lookup to reach the repo, got %d", calls)
+	}
+}
+
+func TestSearchOrders_TrimsQueryAndClampsLimit(t *testing.T) {
+	var gotQuery string
+	var gotLimit int
+	repo := &mockRepo{
+		searchFunc: func(ctx context.Context, query string, limit int) ([]*models.Order, error) {
+			gotQuery, gotLimit = query, limit
+			return nil, nil
+		},
+	}
+	svc := NewOrderService(&mockCache{}, repo, zap.NewNop())
+
+	page, err := svc.SearchOrders(context.Background(), "  ivan  ", 1000)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if gotQuery != "ivan" || gotLimit != MaxListLimit {
+		t.Fatalf("unexpected repo args %q, %d", gotQuery, gotLimit)
+	}
+	if page.Orders == nil {
+		t.Fatalf("expected empty, non-nil orders")
+	}
+
+	if _, err := svc.SearchOrders(context.Background(), " я ", 0); !errors.Is(err, ErrQueryTooShort) {
+		t.Fatalf("expected ErrQueryTooShort, got %v", err)
+	}
+}
+
+func TestGetOrderByTransaction_CacheHitSkipsDB(t *testing.T) {
+	order := sampleOrder()
+	repo := &mockRepo{listFunc: func(ctx context.Context, filter models.OrderFilter) ([]*models.Order, error) {
+		t.Fatal("db must not be queried on a cache hit")
+		return nil, nil
+	}}
+	cache := &mockCache{indexed: map[string][]*models.Order{order.Payment.Transaction: {order}}}
+	svc := NewOrderService(cache, repo, zap.NewNop())
+
+	got, err := svc.GetOrderByTransaction(context.Background(), order.Payment.Transaction)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.OrderUID != order.OrderUID {
+		t.Fatalf("got %q, want %q", got.OrderUID, order.OrderUID)
+	}
+}
+
+func TestGetOrdersByRid_WidePageIsNotCached(t *testing.T) {
+	first, second := sampleOrder(), sampleOrder()
+	second.OrderUID = "o-456"
+	var gotFilter models.OrderFilter
+	repo := &mockRepo{listFunc: func(ctx context.Context, filter models.OrderFilter) ([]*models.Order, error) {
+		gotFilter = filter
+		return []*models.Order{first, second}, nil
+	}}
+	cache := &mockCache{
+		// cached orders with the rid don't stand in for an incomplete result
+		indexed: map[string][]*models.Order{"rid-1": {first}},
+		setFunc: func(o *models.Order) error {
+			t.Fatal("orders of a lookup page must not be cached one by one")
+			return nil
+		},
+	}
+	svc := NewOrderService(cache, repo, zap.NewNop())
+
+	page, err := svc.GetOrdersByRid(context.Background(), "rid-1", models.OrderFilter{Limit: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(page.Orders) != 1 || page.NextCursor == "" || gotFilter.Rid != "rid-1" || gotFilter.Limit != 2 {
+		t.Fatalf("unexpected lookup: page=%+v filter=%+v", page, gotFilter)
+	}
+	if len(cache.stored) != 0 {
+		t.Fatalf("expected a page with more orders behind it not to be cached, got %v", cache.stored)
+	}
+
+	repo.listFunc = nil
+	if _, err := svc.GetOrdersByTrack(context.Background(), "unknown", models.OrderFilter{}); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("expected ErrOrderNotFound, got %v", err)
+	}
+}
+
+func TestGetOrdersByTrack_CompleteResultIsCachedAndServed(t *testing.T) {
+	newer, older := sampleOrder(), sampleOrder()
+	newer.OrderUID, newer.DateCreated = "o-2", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	older.OrderUID, older.DateCreated = "o-1", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	calls := 0
+	repo := &mockRepo{listFunc: func(ctx context.Context, filter models.OrderFilter) ([]*models.Order, error) {
+		calls++
+		return []*models.Order{newer, older}, nil
+	}}
+	cache := &mockCache{}
+	svc := NewOrderService(cache, repo, zap.NewNop())
+
+	if _, err := svc.GetOrdersByTrack(context.Background(), "track-1", models.OrderFilter{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cache.stored["track-1"]) != 2 {
+		t.Fatalf("expected the complete result to be cached, got %v", cache.stored)
+	}
+
+	cache.indexed = map[string][]*models.Order{"track-1": {older, newer}}
+	cache.complete = map[string]bool{"track-1": true}
+	page, err := svc.GetOrdersByTrack(context.Background(), "track-1", models.OrderFilter{Limit: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected a complete track to be served from the cache, got %d repo calls", calls)
+	}
+	if len(page.Orders) != 1 || page.Orders[0].OrderUID != "o-2" || page.NextCursor == "" {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+
+	after, _ := models.DecodeOrderCursor(page.NextCursor)
+	page, _ = svc.GetOrdersByTrack(context.Background(), "track-1", models.OrderFilter{Limit: 1, After: after})
+	if len(page.Orders) != 1 || page.Orders[0].OrderUID != "o-1" || page.NextCursor != "" {
+		t.Fatalf("unexpected second page: %+v", page)
+	}
+
+	// other filters aren't applied to cached orders
+	svc.GetOrdersByTrack(context.Background(), "track-1", models.OrderFilter{Brand: "VS"})
+	cache.indexed["rid-1"], cache.complete["rid-1"] = []*models.Order{older}, true
+	svc.GetOrdersByRid(context.Background(), "rid-1", models.OrderFilter{TrackNumber: "other"})
+	if calls != 3 {
+		t.Fatalf("expected filtered lookups to reach the repo, got %d repo calls", calls)
+	}
+}
+
+func TestGetCustomerSummary_NoOrdersIsNotFound(t *testing.T) {
+	svc := NewOrderService(&mockCache{}, &mockRepo{}, zap.NewNop())
+
+	if _, err := svc.GetCustomerSummary(context.Background(), "nobody"); !errors.Is(err, ErrCustomerNotFound) {
+		t.Fatalf("expected ErrCustomerNotFound, got %v", err)
+	}
+}
+
+func TestImportOrders_ReportsEachOrderAndChunksTransactions(t *testing.T) {
+	var chunks []int
+	repo := &mockRepo{}
+	repo.upsertFunc = func(ctx context.Context, orders []*models.Order) ([]models.BatchResult, error) {
+		chunks = append(chunks, len(orders))
+		if len(chunks) == 2 {
+			return nil, errors.New("db down")
+		}
+		results := make([]models.BatchResult, len(orders))
+		for i, o := range orders {
+			results[i] = models.BatchResult{OrderUID: o.OrderUID, Status: models.BatchUpdated, Version: 2}
+		}
+		return results, nil
+	}
+	var cached int
+	cache := &mockCache{setFunc: func(*models.Order) error { cached++; return nil }}
+	svc := NewOrderService(cache, repo, zap.NewNop())
+
+	invalid := sampleOrder()
+	invalid.TrackNumber = ""
+	invalidDoc, _ := json.Marshal(invalid)
+	docs := []json.RawMessage{[]byte(`{"order_uid": 1}`), invalidDoc}
+	for i := 0; i < ImportChunkSize+1; i++ {
+		o := sampleOrder()
+		o.OrderUID = fmt.Sprintf("o-%d", i)
+		doc, _ := json.Marshal(o)
+		docs = append(docs, doc)
+	}
+
+	report, err := svc.ImportOrders(context.Background(), docs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(chunks) != 2 || chunks[0] != ImportChunkSize || chunks[1] != 1 {
+		t.Fatalf("unexpected chunks: %v", chunks)
+	}
+	if report.Invalid != 2 || report.Updated != ImportChunkSize || report.Failed != 1 {
+		t.Fatalf("unexpected report: created=%d updated=%d invalid=%d failed=%d",
+			report.Created, report.Updated, report.Invalid, report.Failed)
+	}
+	if cached != ImportChunkSize {
+		t.Fatalf("expected %d cached orders, got %d", ImportChunkSize, cached)
+	}
+	last := report.Results[len(docs)-1]
+	if last.Index != len(docs)-1 || last.Status != models.BatchFailed || last.OrderUID != fmt.Sprintf("o-%d", ImportChunkSize) {
+		t.Fatalf("unexpected last result: %+v", last)
+	}
+	if report.Results[1].OrderUID != invalid.OrderUID || report.Results[1].Error == "" {
+		t.Fatalf("unexpected invalid result: %+v", report.Results[1])
+	}
+
+	if _, err := svc.ImportOrders(context.Background(), make([]json.RawMessage, MaxImportOrders+1)); !errors.Is(err, ErrBatchTooLarge) {
+		t.Fatalf("expected ErrBatchTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+
+	"wb-tech-1task/internal/models"
+)
+
+var ErrInvalidStatsFilter = errors.New("invalid stats filter")
+
+const (
+	DefaultStatsRange = 30 * 24 * time.Hour
+	DefaultTopLimit   = 10
+	MaxTopLimit       = 100
+
+	maxStatsEntries = 1000
+)
+
+type StatsRepository interface {
+	OrderVolume(ctx context.Context, filter models.StatsFilter) ([]models.OrderVolume, error)
+	TopBrands(ctx context.Context, filter models.StatsFilter) ([]models.Sales, error)
+	TopProducts(ctx context.Context, filter models.StatsFilter) ([]models.Sales, error)
+	DeliveryShares(ctx context.Context, filter models.StatsFilter) ([]models.DeliveryShare, error)
+}
+
+// StatsService answers analytics queries, caching the result of every
+// distinct query for ttl. A query without a time range covers the last
+// DefaultStatsRange as of the moment its result was computed.
+type StatsService struct {
+	repo   StatsRepository
+	ttl    time.Duration
+	logger *zap.Logger
+	loads  singleflight.Group
+
+	mu      sync.Mutex
+	results map[string]statsResult
+}
+
+type statsResult struct {
+	value     any
+	expiresAt time.Time
+}
+
+func NewStatsService(repo StatsRepository, ttl time.Duration, logger *zap.Logger) *StatsService {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &StatsService{
+		repo:    repo,
+		ttl:     ttl,
+		logger:  logger,
+		results: make(map[string]statsResult),
+	}
+}
+
+func (s *StatsService) OrderVolume(ctx context.Context, filter models.StatsFilter) ([]models.OrderVolume, error) {
+	switch filter.Bucket {
+	case "":
+		filter.Bucket = models.BucketDay
+	case models.BucketHour, models.BucketDay, models.BucketWeek:
+	default:
+		return nil, fmt.Errorf("%w: bucket must be hour, day or week", ErrInvalidStatsFilter)
+	}
+	filter.Limit = 0
+	return cachedStats(ctx, s, "volume", filter, s.repo.OrderVolume)
+}
+
+func (s *StatsService) TopBrands(ctx context.Context, filter models.StatsFilter) ([]models.Sales, error) {
+	filter.Bucket = ""
+	filter.Limit = topLimit(filter.Limit)
+	return cachedStats(ctx, s, "brands", filter, s.repo.TopBrands)
+}
+
+func (s *StatsService) TopProducts(ctx context.Context, filter models.StatsFilter) ([]models.Sales, error) {
+	filter.Bucket = ""
+	filter.Limit = topLimit(filter.Limit)
+	return cachedStats(ctx, s, "products", filter, s.repo.TopProducts)
+}
+
+func (s *StatsService) DeliveryShares(ctx context.Context, filter models.StatsFilter) ([]models.DeliveryShare, error) {
+	filter = models.StatsFilter{From: filter.From, To: filter.To, Currency: filter.Currency}
+	return cachedStats(ctx, s, "delivery", filter, s.repo.DeliveryShares)
+}
+
+func topLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultTopLimit
+	}
+	if limit > MaxTopLimit {
+		return MaxTopLimit
+	}
+	return limit
+}
+
+// cachedStats returns the cached result for the query or loads it. The cache
+// key is taken before the default time range is filled in, so open-ended
+// queries share one entry.
+func cachedStats[T any](ctx context.Context, s *StatsService, name string, filter models.StatsFilter,
+	load func(context.Context, models.StatsFilter) ([]T, error)) ([]T, error) {
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidStatsFilter)
+	}
+
+	key := fmt.Sprintf("%s|%d|%d|%s|%s|%d", name, filter.From.UnixNano(), filter.To.UnixNano(),
+		filter.Bucket, filter.Currency, filter.Limit)
+	if v, ok := s.lookup(key); ok {
+		return v.([]T), nil
+	}
+
+	v, err, _ := s.loads.Do(key, func() (any, error) {
+		if filter.To.IsZero() {
+			filter.To = time.Now()
+		}
+		if filter.From.IsZero() {
+			filter.From = filter.To.Add(-DefaultStatsRange)
+		}
+
+		result, err := load(context.WithoutCancel(ctx), filter)
+		if err != nil {
+			s.logger.Error("stats query failed", zap.String("stats", name), zap.Error(err))
+			return nil, err
+		}
+		if result == nil {
+			result = []T{}
+		}
+		s.store(key, result)
+		return result, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]T), nil
+}
+
+func (s *StatsService) lookup(key string) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.results[key]
+	if !ok || time.Now().After(r.expiresAt) {
+		return nil, false
+	}
+	return r.value, true
+}
+
+func (s *StatsService) store(key string, value any) {
+	if s.ttl <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if len(s.results) >= maxStatsEntries {
+		for k, r := range s.results {
+			if now.After(r.expiresAt) {
+				delete(s.results, k)
+			}
+		}
+		if len(s.results) >= maxStatsEntries {
+			return
+		}
+	}
+	s.results[key] = statsResult{value: value, expiresAt: now.Add(s.ttl)}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"wb-tech-1task/internal/models"
+)
+
+type statsRepo struct {
+	calls   int
+	filters []models.StatsFilter
+}
+
+func (r *statsRepo) OrderVolume(ctx context.Context, filter models.StatsFilter) ([]models.OrderVolume, error) {
+	r.calls++
+	r.filters = append(r.filters, filter)
+	return []models.OrderVolume{{Currency: "USD", Orders: 1}}, nil
+}
+func (r *statsRepo) TopBrands(ctx context.Context, filter models.StatsFilter) ([]models.Sales, error) {
+	r.calls++
+	r.filters = append(r.filters, filter)
+	return nil, nil
+}
+func (r *statsRepo) TopProducts(ctx context.Context, filter models.StatsFilter) ([]models.Sales, error) {
+	r.calls++
+	r.filters = append(r.filters, filter)
+	return nil, nil
+}
+func (r *statsRepo) DeliveryShares(ctx context.Context, filter models.StatsFilter) ([]models.DeliveryShare, error) {
+	r.calls++
+	r.filters = append(r.filters, filter)
+	return nil, nil
+}
+
+func TestStatsService_CachesOpenEndedQueries(t *testing.T) {
+	repo := &statsRepo{}
+	svc := NewStatsService(repo, time.Minute, zap.NewNop())
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := svc.OrderVolume(ctx, models.StatsFilter{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if repo.calls != 1 {
+		t.Fatalf("expected one repo call, got %d", repo.calls)
+	}
+	f := repo.filters[0]
+	if f.Bucket != models.BucketDay || f.To.Sub(f.From) != DefaultStatsRange {
+		t.Fatalf("unexpected defaults: %+v", f)
+	}
+
+	// another bucket is another query
+	if _, err := svc.OrderVolume(ctx, models.StatsFilter{Bucket: models.BucketHour}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if repo.calls != 2 {
+		t.Fatalf("expected two repo calls, got %d", repo.calls)
+	}
+}
+
+func TestStatsService_TopClampsLimitAndReturnsEmptyList(t *testing.T) {
+	repo := &statsRepo{}
+	svc := NewStatsService(repo, 0, zap.NewNop())
+
+	sales, err := svc.TopBrands(context.Background(), models.StatsFilter{Limit: 1000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sales == nil || len(sales) != 0 {
+		t.Fatalf("expected an empty list, got %#v", sales)
+	}
+	if repo.filters[0].Limit != MaxTopLimit {
+		t.Fatalf("expected limit %d, got %d", MaxTopLimit, repo.filters[0].Limit)
+	}
+
+	// ttl 0 disables caching
+	svc.TopBrands(context.Background(), models.StatsFilter{Limit: 1000})
+	if repo.calls != 2 {
+		t.Fatalf("expected two repo calls, got %d", repo.calls)
+	}
+}
+
+func TestStatsService_RejectsInvalidFilter(t *testing.T) {
+	svc := NewStatsService(&statsRepo{}, time.Minute, zap.NewNop())
+	now := time.Now()
+
+	if _, err := svc.OrderVolume(context.Background(), models.StatsFilter{Bucket: "month"}); !errors.Is(err, ErrInvalidStatsFilter) {
+		t.Fatalf("expected ErrInvalidStatsFilter for bucket, got %v", err)
+	}
+	if _, err := svc.DeliveryShares(context.Background(), models.StatsFilter{From: now, To: now.Add(-time.Hour)}); !errors.Is(err, ErrInvalidStatsFilter) {
+		t.Fatalf("expected ErrInvalidStatsFilter for range, got %v", err)
+	}
+}
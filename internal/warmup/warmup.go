@@ -0,0 +1,127 @@
+package warmup
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"wb-tech-1task/internal/models"
+)
+
+const (
+	StatePending   = "pending"
+	StateRunning   = "running"
+	StateDone      = "done"
+	StateFailed    = "failed"
+	StateCacheFull = "cache_full"
+)
+
+var errCacheFull = errors.New("cache is full")
+
+type OrderStreamer interface {
+	StreamOrders(ctx context.Context, batchSize, limit int, fn func([]*models.Order) error) error
+}
+
+type CacheFiller interface {
+	Backfill(orders []*models.Order) (int, bool)
+}
+
+type Progress struct {
+	State      string     `json:"state"`
+	Loaded     int        `json:"loaded"`
+	Limit      int        `json:"limit,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// Warmer fills the cache from Postgres in the background. Requests keep
+// being served while it runs; cache misses simply fall back to the DB.
+type Warmer struct {
+	repo      OrderStreamer
+	cache     CacheFiller
+	batchSize int
+	limit     int
+	logger    *zap.Logger
+
+	mu       sync.RWMutex
+	progress Progress
+}
+
+func New(repo OrderStreamer, cache CacheFiller, batchSize, limit int, logger *zap.Logger) *Warmer {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Warmer{
+		repo:      repo,
+		cache:     cache,
+		batchSize: batchSize,
+		limit:     limit,
+		logger:    logger,
+		progress:  Progress{State: StatePending, Limit: limit},
+	}
+}
+
+func (w *Warmer) Run(ctx context.Context) error {
+	started := time.Now()
+	w.update(func(p *Progress) {
+		p.State = StateRunning
+		p.StartedAt = &started
+	})
+	w.logger.Info("cache warm-up started", zap.Int("batch_size", w.batchSize), zap.Int("limit", w.limit))
+
+	err := w.repo.StreamOrders(ctx, w.batchSize, w.limit, func(orders []*models.Order) error {
+		added, full := w.cache.Backfill(orders)
+		loaded := 0
+		w.update(func(p *Progress) {
+			p.Loaded += added
+			loaded = p.Loaded
+		})
+		w.logger.Info("cache warm-up progress", zap.Int("loaded", loaded))
+		if full {
+			return errCacheFull
+		}
+		return nil
+	})
+
+	finished := time.Now()
+	p := w.update(func(p *Progress) {
+		p.FinishedAt = &finished
+		switch {
+		case err == nil:
+			p.State = StateDone
+		case errors.Is(err, errCacheFull):
+			p.State = StateCacheFull
+		default:
+			p.State = StateFailed
+			p.Error = err.Error()
+		}
+	})
+
+	if p.State == StateFailed {
+		w.logger.Error("cache warm-up failed", zap.Int("loaded", p.Loaded), zap.Error(err))
+		return err
+	}
+	w.logger.Info("cache warm-up finished",
+		zap.String("state", p.State),
+		zap.Int("loaded", p.Loaded),
+		zap.Duration("duration", finished.Sub(started)),
+	)
+	return nil
+}
+
+func (w *Warmer) Progress() Progress {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.progress
+}
+
+func (w *Warmer) update(fn func(p *Progress)) Progress {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fn(&w.progress)
+	return w.progress
+}
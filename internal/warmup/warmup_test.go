@@ -0,0 +1,86 @@
+package warmup
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"wb-tech-1task/internal/models"
+)
+
+type fakeStreamer struct {
+	batches [][]*models.Order
+	err     error
+}
+
+func (f *fakeStreamer) StreamOrders(ctx context.Context, batchSize, limit int, fn func([]*models.Order) error) error {
+	for _, b := range f.batches {
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+	return f.err
+}
+
+type fakeFiller struct {
+	capacity int
+	stored   []string
+}
+
+func (f *fakeFiller) Backfill(orders []*models.Order) (int, bool) {
+	added := 0
+	for _, o := range orders {
+		if f.capacity > 0 && len(f.stored) >= f.capacity {
+			return added, true
+		}
+		f.stored = append(f.stored, o.OrderUID)
+		added++
+	}
+	return added, false
+}
+
+func TestWarmer_LoadsAllBatches(t *testing.T) {
+	repo := &fakeStreamer{batches: [][]*models.Order{
+		{{OrderUID: "a"}, {OrderUID: "b"}},
+		{{OrderUID: "c"}},
+	}}
+	filler := &fakeFiller{}
+	w := New(repo, filler, 2, 0, nil)
+
+	assert.Equal(t, StatePending, w.Progress().State)
+	assert.NoError(t, w.Run(context.Background()))
+
+	p := w.Progress()
+	assert.Equal(t, StateDone, p.State)
+	assert.Equal(t, 3, p.Loaded)
+	assert.NotNil(t, p.FinishedAt)
+	assert.Equal(t, []string{"a", "b", "c"}, filler.stored)
+}
+
+func TestWarmer_StopsWhenCacheFull(t *testing.T) {
+	repo := &fakeStreamer{batches: [][]*models.Order{
+		{{OrderUID: "a"}, {OrderUID: "b"}},
+		{{OrderUID: "c"}},
+	}}
+	w := New(repo, &fakeFiller{capacity: 1}, 2, 0, nil)
+
+	assert.NoError(t, w.Run(context.Background()))
+	assert.Equal(t, StateCacheFull, w.Progress().State)
+	assert.Equal(t, 1, w.Progress().Loaded)
+}
+
+func TestWarmer_ReportsFailure(t *testing.T) {
+	repo := &fakeStreamer{
+		batches: [][]*models.Order{{{OrderUID: "a"}}},
+		err:     errors.New("connection reset"),
+	}
+	w := New(repo, &fakeFiller{}, 2, 0, nil)
+
+	assert.Error(t, w.Run(context.Background()))
+	p := w.Progress()
+	assert.Equal(t, StateFailed, p.State)
+	assert.Equal(t, 1, p.Loaded)
+	assert.Equal(t, "connection reset", p.Error)
+}
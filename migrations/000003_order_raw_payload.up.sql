@@ -0,0 +1,2 @@
+-- databases created from init.sql lack the column defined in 000001
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS raw_payload JSONB;
@@ -0,0 +1,13 @@
+-- change log of orders; rows outlive the order itself, so no foreign key
+CREATE TABLE IF NOT EXISTS order_history (
+id BIGSERIAL PRIMARY KEY,
+order_uid VARCHAR(50) NOT NULL,
+version INTEGER NOT NULL,
+operation VARCHAR(10) NOT NULL,
+snapshot JSONB,
+source VARCHAR(10),
+source_ref VARCHAR(255),
+changed_at TIMESTAMPTZ DEFAULT now() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_history_order_uid ON order_history(order_uid, id);
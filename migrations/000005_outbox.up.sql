@@ -0,0 +1,9 @@
+-- order events written with the order and relayed to kafka
+CREATE TABLE IF NOT EXISTS outbox (
+id BIGSERIAL PRIMARY KEY,
+event_type VARCHAR(50) NOT NULL,
+order_uid VARCHAR(50) NOT NULL,
+version INTEGER NOT NULL,
+payload JSONB,
+created_at TIMESTAMPTZ DEFAULT now() NOT NULL
+);
@@ -0,0 +1,7 @@
+-- keys of kafka messages and http requests whose changes are already stored
+CREATE TABLE IF NOT EXISTS processed_messages (
+message_key VARCHAR(300) PRIMARY KEY,
+order_uid VARCHAR(50) NOT NULL,
+request_hash VARCHAR(64),
+processed_at TIMESTAMPTZ DEFAULT now() NOT NULL
+);
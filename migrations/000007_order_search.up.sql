@@ -0,0 +1,29 @@
+-- full-text and trigram search over order, delivery and item fields
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS search_text TEXT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS search_vector TSVECTOR;
+
+-- called by the repository after every insert or update of an order
+CREATE OR REPLACE FUNCTION refresh_order_search(uid VARCHAR) RETURNS void AS $$
+UPDATE orders o SET
+search_text = concat_ws(' ', o.order_uid, o.track_number, d.name, d.phone, d.email, d.city, it.names, it.brands),
+search_vector =
+	setweight(to_tsvector('simple', concat_ws(' ', o.order_uid, o.track_number)), 'A') ||
+	setweight(to_tsvector('simple', concat_ws(' ', d.name, d.phone, d.email)), 'B') ||
+	setweight(to_tsvector('simple', coalesce(d.city, '')), 'C') ||
+	setweight(to_tsvector('simple', concat_ws(' ', it.names, it.brands)), 'D')
+FROM (SELECT 1) AS one
+LEFT JOIN delivery d ON d.order_uid = uid
+LEFT JOIN LATERAL (
+	SELECT string_agg(i.name, ' ') AS names, string_agg(DISTINCT i.brand, ' ') AS brands
+	FROM items i
+	WHERE i.order_uid = uid
+) it ON true
+WHERE o.order_uid = uid;
+$$ LANGUAGE sql;
+
+SELECT refresh_order_search(order_uid) FROM orders;
+
+CREATE INDEX IF NOT EXISTS idx_orders_search_vector ON orders USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_orders_search_text_trgm ON orders USING GIN (search_text gin_trgm_ops);
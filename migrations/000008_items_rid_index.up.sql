@@ -0,0 +1,2 @@
+-- lookups by item rid
+CREATE INDEX IF NOT EXISTS idx_items_rid ON items(rid);
@@ -0,0 +1,2 @@
+-- business-rule warnings recorded when the order was last written
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS warnings JSONB;
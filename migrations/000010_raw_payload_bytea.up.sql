@@ -0,0 +1,9 @@
+-- keep the received bytes as they were: jsonb reorders keys and drops whitespace
+DO $$
+BEGIN
+    IF (SELECT data_type FROM information_schema.columns
+        WHERE table_name = 'orders' AND column_name = 'raw_payload') = 'jsonb' THEN
+        ALTER TABLE orders ALTER COLUMN raw_payload TYPE BYTEA USING convert_to(raw_payload::text, 'UTF8');
+    END IF;
+END
+$$;
@@ -0,0 +1,2 @@
+-- processed_messages older than the retention are deleted by processed_at
+CREATE INDEX IF NOT EXISTS idx_processed_messages_processed_at ON processed_messages (processed_at);
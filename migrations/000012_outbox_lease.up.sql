@@ -0,0 +1,3 @@
+-- relays lease outbox rows instead of holding row locks while publishing
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_outbox_order_uid ON outbox (order_uid, id);
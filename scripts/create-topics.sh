@@ -4,7 +4,10 @@ set -euo pipefail
 BOOTSTRAP="kafka:9092"
 TOPICS=(
   "orders:3:1"
+  "orders_retry_5s:3:1"
+  "orders_retry_1m:3:1"
   "orders_dead_letter:1:1"
+  "order_events:3:1"
 )
 
 for i in $(seq 1 30); do
@@ -28,6 +28,58 @@ function getOrder() {
         });
 }
 
+function searchOrders() {
+    const query = document.getElementById('searchQuery').value.trim();
+    if (query.length < 2) {
+        showError('Please enter at least 2 characters');
+        return;
+    }
+
+    showLoading();
+
+    fetch(`http://localhost:8080/orders/search?q=${encodeURIComponent(query)}`)
+        .then(response => {
+            if (!response.ok) {
+                throw new Error('Server error: ' + response.status);
+            }
+            return response.json();
+        })
+        .then(data => {
+            displaySearchResults(data.orders);
+        })
+        .catch(error => {
+            showError('Error: ' + error.message);
+        });
+}
+
+function displaySearchResults(orders) {
+    if (orders.length === 0) {
+        showError('No orders found');
+        return;
+    }
+
+    document.getElementById('orderResult').innerHTML = `
+        <h2>Found ${orders.length} order(s)</h2>
+        ${orders.map((order, i) => `
+            <div class="search-result" data-index="${i}">
+                <p><strong>${escapeHTML(order.order_uid)}</strong> &mdash; ${escapeHTML(order.track_number)}</p>
+                <p>${escapeHTML(order.delivery.name)}, ${escapeHTML(order.delivery.city)}, ${escapeHTML(order.delivery.phone)}</p>
+                <p>${order.items.map(item => escapeHTML(item.brand + ' ' + item.name)).join(', ')}</p>
+            </div>
+        `).join('')}
+    `;
+
+    document.querySelectorAll('.search-result').forEach(el => {
+        el.addEventListener('click', () => displayOrder(orders[el.dataset.index]));
+    });
+}
+
+function escapeHTML(value) {
+    const div = document.createElement('div');
+    div.textContent = value == null ? '' : String(value);
+    return div.innerHTML;
+}
+
 function showLoading() {
     document.getElementById('orderResult').innerHTML = `
         <div class="loading">Loading...</div>
@@ -100,4 +152,10 @@ document.getElementById('orderUid').addEventListener('keypress', function(e) {
     if (e.key === 'Enter') {
         getOrder();
     }
+});
+
+document.getElementById('searchQuery').addEventListener('keypress', function(e) {
+    if (e.key === 'Enter') {
+        searchOrders();
+    }
 });
\ No newline at end of file